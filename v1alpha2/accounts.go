@@ -0,0 +1,238 @@
+package assistant
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+)
+
+// AccountCallback holds a callback function to return an account's OAuth2 token to whenever it changes
+type AccountCallback func(key string, token *oauth2.Token)
+
+// AccountManager links many Google accounts to one application, routing every sign in through a single shared OAuth2 callback endpoint
+type AccountManager struct {
+	Credentials   *Token
+	LanguageCode  string
+	Device        *Device
+	AudioSettings *AudioSettings
+
+	CallbackFunc  AccountCallback             //Optional, receives every new token of every account
+	NewTokenStore func(key string) TokenStore //Optional, persists the token of each account
+	Endpoint      string                      //Shared with every account, see Assistant.Endpoint
+	Insecure      bool                        //Shared with every account, see Assistant.Insecure
+	DialOptions   []grpc.DialOption           //Shared with every account, see Assistant.DialOptions
+	ClientOptions []option.ClientOption       //Shared with every account, see Assistant.ClientOptions
+	OauthEndpoint *oauth2.Endpoint            //Optional, overrides GoogleEndpoint for every account
+	OauthSrv      *http.Server                //Only set when listening with Listen
+	RedirectURL   string                      //Address of the shared callback endpoint
+
+	mutex    sync.Mutex
+	accounts map[string]*Assistant
+	pending  map[string]string //Sign in state to account key
+}
+
+// NewAccountManager returns a new account manager, with redirectURL pointing at wherever its Handler is served from
+// An empty redirectURL uses the first redirect URL of the credentials, which is usually a loopback address to be served with Listen
+func NewAccountManager(credentials *Token, redirectURL, languageCode string, device *Device, audioSettings *AudioSettings) *AccountManager {
+	if redirectURL == "" {
+		redirectURL = credentials.redirectURL()
+	}
+	return &AccountManager{
+		Credentials:   credentials,
+		LanguageCode:  languageCode,
+		Device:        device,
+		AudioSettings: audioSettings,
+		RedirectURL:   redirectURL,
+		accounts:      make(map[string]*Assistant),
+		pending:       make(map[string]string),
+	}
+}
+
+// Handler returns the shared OAuth2 callback endpoint, for mounting at RedirectURL on the application's own server
+func (m *AccountManager) Handler() http.Handler {
+	return http.HandlerFunc(m.oauthHandler)
+}
+
+// Listen serves the shared OAuth2 callback endpoint on its own server at internalHost, which picks a free port when given a port of 0 or an empty host
+func (m *AccountManager) Listen(internalHost string) error {
+	if internalHost == "" {
+		internalHost = "127.0.0.1:0"
+	}
+	listener, err := net.Listen("tcp", internalHost)
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	m.RedirectURL = loopbackRedirectURL(m.RedirectURL, listener.Addr())
+	m.OauthSrv = &http.Server{
+		Handler:           m.Handler(),
+		ReadHeaderTimeout: time.Second * 10,
+	}
+	srv := m.OauthSrv
+	m.mutex.Unlock()
+
+	go srv.Serve(listener)
+	return nil
+}
+
+// Assistant returns the Assistant of an account, creating it from its stored token if it hasn't been used yet
+func (m *AccountManager) Assistant(key string) (*Assistant, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.assistant(key)
+}
+
+func (m *AccountManager) assistant(key string) (*Assistant, error) {
+	if assistant, ok := m.accounts[key]; ok {
+		return assistant, nil
+	}
+
+	var tokenStore TokenStore
+	var oauthToken *oauth2.Token
+	if m.NewTokenStore != nil {
+		tokenStore = m.NewTokenStore(key)
+		var err error
+		oauthToken, err = tokenStore.Load()
+		if err != nil {
+			return nil, fmt.Errorf("error loading token for %s: %v", key, err)
+		}
+	}
+
+	assistant := newAssistant(oauthToken, m.LanguageCode, m.Device, m.AudioSettings)
+	assistant.Endpoint = m.Endpoint
+	assistant.Insecure = m.Insecure
+	assistant.DialOptions = m.DialOptions
+	assistant.ClientOptions = m.ClientOptions
+	assistant.GCPAuth.TokenStore = tokenStore
+	assistant.GCPAuth.Endpoint = m.OauthEndpoint
+	if m.Credentials != nil {
+		assistant.GCPAuth.Config = assistant.GCPAuth.newConfig(m.Credentials, m.RedirectURL)
+	}
+	assistant.GCPAuth.relink = func() (string, error) {
+		return m.Link(key)
+	}
+	if m.CallbackFunc != nil {
+		callbackFunc := m.CallbackFunc
+		assistant.GCPAuth.CallbackFunc = func(token *oauth2.Token) {
+			callbackFunc(key, token)
+		}
+	}
+
+	m.accounts[key] = &assistant
+	return &assistant, nil
+}
+
+// Link starts signing an account in and returns the auth URL the user must visit, completion is signalled through the account's GCPAuthWrapper.Done
+func (m *AccountManager) Link(key string) (string, error) {
+	if m.Credentials == nil {
+		return "", fmt.Errorf("no credentials to sign %s in with", key)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	assistant, err := m.assistant(key)
+	if err != nil {
+		return "", err
+	}
+	for state, pendingKey := range m.pending {
+		if pendingKey == key {
+			delete(m.pending, state) //Only the latest sign in of an account is accepted
+		}
+	}
+
+	assistant.GCPAuth.Config = assistant.GCPAuth.newConfig(m.Credentials, m.RedirectURL)
+	state, err := assistant.GCPAuth.beginAuth()
+	if err != nil {
+		return "", err
+	}
+	m.pending[state] = key
+	return assistant.GCPAuth.authURL(), nil
+}
+
+// Unlink signs an account out and forgets it
+func (m *AccountManager) Unlink(ctx context.Context, key string) error {
+	m.mutex.Lock()
+	assistant, err := m.assistant(key)
+	if err == nil {
+		delete(m.accounts, key)
+		for state, pendingKey := range m.pending {
+			if pendingKey == key {
+				delete(m.pending, state)
+			}
+		}
+	}
+	m.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	logoutErr := assistant.Logout(ctx)
+	assistant.Close()
+	return logoutErr
+}
+
+// Keys returns the keys of every account that has been used, in order
+func (m *AccountManager) Keys() []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	keys := make([]string, 0, len(m.accounts))
+	for key := range m.accounts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Close closes every account's Assistant and stops the shared callback endpoint, if it was started with Listen
+func (m *AccountManager) Close() {
+	m.mutex.Lock()
+	accounts := m.accounts
+	m.accounts = make(map[string]*Assistant)
+	m.pending = make(map[string]string)
+	srv := m.OauthSrv
+	m.mutex.Unlock()
+
+	for _, assistant := range accounts {
+		assistant.Close()
+	}
+	if srv != nil {
+		srv.Shutdown(context.Background())
+	}
+}
+
+// oauthHandler routes a sign in to the account that started it
+func (m *AccountManager) oauthHandler(writer http.ResponseWriter, req *http.Request) {
+	state := req.URL.Query().Get("state")
+
+	m.mutex.Lock()
+	key, ok := m.pending[state]
+	assistant := m.accounts[key]
+	m.mutex.Unlock()
+	if !ok || assistant == nil {
+		writeAuthPage(writer, http.StatusBadRequest, "Authentication Failure", "The sign-in request didn't come from this application.")
+		return
+	}
+
+	assistant.GCPAuth.oauthHandler(writer, req)
+
+	assistant.GCPAuth.mutex.Lock()
+	finished := assistant.GCPAuth.state != state
+	assistant.GCPAuth.mutex.Unlock()
+	if finished {
+		m.mutex.Lock()
+		if m.pending[state] == key {
+			delete(m.pending, state)
+		}
+		m.mutex.Unlock()
+	}
+}
@@ -2,6 +2,9 @@ package assistant
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log"
 	"time"
 
 	"golang.org/x/oauth2"
@@ -9,14 +12,18 @@ import (
 	"google.golang.org/api/transport"
 	gassist "google.golang.org/genproto/googleapis/assistant/embedded/v1alpha2"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
 )
 
+// DefaultEndpoint is the address of Google's Assistant API
+const DefaultEndpoint = "embeddedassistant.googleapis.com:443"
+
 // Assistant holds the Google Assistant and methods to interact with it
 type Assistant struct {
 	//The real deal
 	GoogleAssistant gassist.EmbeddedAssistantClient
 	Conversation    gassist.EmbeddedAssistant_AssistClient
-	DialogState     *gassist.DialogStateIn
+	DialogState     *gassist.DialogStateIn //Default dialog state of every new conversation, which each conversation carries on from a copy of
 
 	//Assistant configuration
 	AudioSettings *AudioSettings
@@ -29,14 +36,73 @@ type Assistant struct {
 	Canceler   context.CancelFunc
 	Connection *grpc.ClientConn
 	Context    context.Context
+
+	//Connection configuration
+	Endpoint      string                //Address of the Assistant API, defaults to DefaultEndpoint
+	Insecure      bool                  //Dial without TLS or authentication, for local stand-in servers
+	DialOptions   []grpc.DialOption     //Extra gRPC dial options, such as a bufconn dialer
+	ClientOptions []option.ClientOption //Extra Google API client options
+	RetryPolicy   *RetryPolicy          //How failed queries are retried, defaults to DefaultRetryPolicy
+	Timeouts      Timeouts              //How long each part of talking to the Assistant may take
+
+	Keepalive        *keepalive.ClientParameters //How the connection is kept alive, defaults to DefaultKeepalive
+	CloseGracePeriod time.Duration               //How long Close waits for in-flight turns, defaults to DefaultCloseGracePeriod
+	Logger           *log.Logger                 //Optional, receives retries, re-authentication and other notable events
+
+	conn *connection
 }
 
 // NewAssistant returns a new Google Assistant to operate on
 func NewAssistant(token *Token, oauthToken *oauth2.Token, callbackFunc TokenCallback, internalHost, languageCode string, device *Device, audioSettings *AudioSettings) (Assistant, error) {
-	assistant := Assistant{
+	if oauthToken == nil {
+		oauthToken = token.OauthToken()
+	}
+	assistant := newAssistant(oauthToken, languageCode, device, audioSettings)
+	return assistant, assistant.authenticate(token, internalHost, callbackFunc)
+}
+
+// NewAssistantWithTokenStore returns a new Google Assistant to operate on, loading its OAuth2 token from tokenStore and saving every new token back to it
+func NewAssistantWithTokenStore(token *Token, tokenStore TokenStore, callbackFunc TokenCallback, internalHost, languageCode string, device *Device, audioSettings *AudioSettings) (Assistant, error) {
+	oauthToken, err := tokenStore.Load()
+	if err != nil {
+		return Assistant{}, fmt.Errorf("error loading token: %v", err)
+	}
+	if oauthToken == nil {
+		oauthToken = token.OauthToken()
+	}
+
+	assistant := newAssistant(oauthToken, languageCode, device, audioSettings)
+	assistant.GCPAuth.TokenStore = tokenStore
+	return assistant, assistant.authenticate(token, internalHost, callbackFunc)
+}
+
+// NewAssistantWithTokenSource returns a new Google Assistant to operate on, authenticating every request with tokens from tokenSource instead of signing in
+func NewAssistantWithTokenSource(tokenSource oauth2.TokenSource, languageCode string, device *Device, audioSettings *AudioSettings) Assistant {
+	assistant := newAssistant(nil, languageCode, device, audioSettings)
+	assistant.GCPAuth.UseTokenSource(tokenSource)
+	return assistant
+}
+
+// authenticate prepares to refresh the current token, only asking the user to sign in if there isn't a usable one
+func (a *Assistant) authenticate(token *Token, internalHost string, callbackFunc TokenCallback) error {
+	if token != nil {
+		a.GCPAuth.Config = a.GCPAuth.newConfig(token, token.redirectURL())
+	}
+	if callbackFunc != nil {
+		a.GCPAuth.CallbackFunc = callbackFunc
+	}
+	if a.GCPAuth.authenticated() {
+		return nil
+	}
+	return a.GCPAuth.Initialize(token, internalHost, callbackFunc)
+}
+
+func newAssistant(oauthToken *oauth2.Token, languageCode string, device *Device, audioSettings *AudioSettings) Assistant {
+	return Assistant{
 		AudioSettings: audioSettings,
 		Device:        device,
 		GCPAuth:       &GCPAuthWrapper{OauthToken: oauthToken},
+		conn:          &connection{},
 		LanguageCode:  languageCode,
 		DialogState: &gassist.DialogStateIn{
 			ConversationState: make([]byte, 0),
@@ -44,75 +110,118 @@ func NewAssistant(token *Token, oauthToken *oauth2.Token, callbackFunc TokenCall
 			IsNewConversation: true,
 		},
 	}
-	if oauthToken.Valid() {
-		return assistant, nil
+}
+
+// logf logs a notable event if the Assistant has a logger
+func (a *Assistant) logf(format string, v ...interface{}) {
+	if a.Logger != nil {
+		a.Logger.Printf(format, v...)
+	}
+}
+
+// AuthURL returns the Google authentication URL to sign into your Google account, only if you actually need to
+func (a *Assistant) AuthURL() (string, error) {
+	if a.GCPAuth == nil {
+		return "", ErrNotInitialized
 	}
-	return assistant, assistant.GCPAuth.Initialize(token, internalHost, callbackFunc)
+	return a.GetAuthURL(), nil
 }
 
 // GetAuthURL returns the Google authentication URL to sign into your Google account, only if you actually need to
 // Also acts as a token refresh mechanism when running into auth issues
+//
+// Deprecated: GetAuthURL returns "ERROR" if the Assistant wasn't initialized, use AuthURL instead
 func (a *Assistant) GetAuthURL() string {
 	if a.GCPAuth == nil {
 		return "ERROR" //Must initialize with NewAssistant!
 	}
-	if a.GCPAuth.OauthToken == nil || !a.GCPAuth.OauthToken.Valid() {
-		return a.GCPAuth.AuthURL
+	if !a.GCPAuth.authenticated() {
+		return a.GCPAuth.authURL()
 	}
 	return ""
 }
 
-func (a *Assistant) newConnection(ctx context.Context) (conn *grpc.ClientConn, err error) {
-	tokenSource := a.GCPAuth.Config.TokenSource(ctx, a.GCPAuth.OauthToken)
-	return transport.DialGRPC(ctx,
-		option.WithTokenSource(tokenSource),
-		option.WithEndpoint("embeddedassistant.googleapis.com:443"),
-		option.WithScopes("https://www.googleapis.com/auth/assistant-sdk-prototype"),
-	)
+// SetEndpoint points the Assistant at another server, such as a local stand-in for testing, and must be called before starting a conversation
+// Insecure connections skip both TLS and authentication, and dialOptions may be used to dial in-process connections such as bufconn
+func (a *Assistant) SetEndpoint(endpoint string, insecure bool, dialOptions ...grpc.DialOption) {
+	a.Endpoint = endpoint
+	a.Insecure = insecure
+	a.DialOptions = append(a.DialOptions, dialOptions...)
 }
 
-// NewConversation starts a new conversation and returns it, it's the caller's job to close it
-func (a *Assistant) NewConversation(timeout time.Duration) (*Conversation, error) {
-	var err error
-	err = a.GCPAuth.Error()
-	if err != nil {
-		return nil, err
+func (a *Assistant) newConnection(ctx context.Context) (conn *grpc.ClientConn, err error) {
+	endpoint := a.Endpoint
+	if endpoint == "" {
+		endpoint = DefaultEndpoint
 	}
 
-	if int64(timeout) > 0 {
-		a.Context, a.Canceler = context.WithDeadline(context.Background(), time.Now().Add(timeout))
-	} else {
-		a.Context = context.Background()
+	opts := []option.ClientOption{
+		option.WithEndpoint(endpoint),
+		option.WithScopes("https://www.googleapis.com/auth/assistant-sdk-prototype"),
 	}
-
-	a.Connection, err = a.newConnection(a.Context)
-	if err != nil {
-		return nil, err
+	keepaliveParams := DefaultKeepalive
+	if a.Keepalive != nil {
+		keepaliveParams = *a.Keepalive
 	}
+	opts = append(opts, option.WithGRPCDialOption(grpc.WithKeepaliveParams(keepaliveParams)))
+	for _, dialOption := range a.DialOptions {
+		opts = append(opts, option.WithGRPCDialOption(dialOption))
+	}
+	opts = append(opts, a.ClientOptions...)
 
-	a.GoogleAssistant = gassist.NewEmbeddedAssistantClient(a.Connection)
+	if timeout := a.Timeouts.Dial; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, timeout, &TimeoutError{Err: ErrDialTimeout, Timeout: timeout})
+		defer cancel()
+		opts = append(opts, option.WithGRPCDialOption(grpc.WithBlock())) //Wait for the connection to be ready, so a dead one is noticed
+		defer func() {
+			if err != nil && errors.Is(context.Cause(ctx), ErrDialTimeout) {
+				err = context.Cause(ctx)
+			}
+		}()
+	}
 
-	conversation := &Conversation{
-		Assistant: a,
+	if a.Insecure {
+		return transport.DialGRPCInsecure(ctx, opts...)
 	}
 
-	return conversation, nil
+	return transport.DialGRPC(ctx, append([]option.ClientOption{option.WithTokenSource(a.GCPAuth.TokenSource())}, opts...)...)
 }
 
-// Close closes the connection to the Assistant and cleans up all resources, except for conversations which must be handled by the caller
-func (a *Assistant) Close() {
+// NewConversation starts a new conversation and returns it, it's the caller's job to close it
+// A non-zero timeout ends the conversation once it expires, no matter how healthy its turns are, see Timeouts for limits on each part of a turn instead
+func (a *Assistant) NewConversation(timeout time.Duration) (*Conversation, error) {
+	ctx := context.Background()
 	if a.Context != nil {
-		a.Context.Done()
+		ctx = a.Context
 	}
-	if a.Canceler != nil {
-		a.Canceler()
+	stop := context.CancelFunc(func() {})
+	if int64(timeout) > 0 {
+		ctx, stop = context.WithDeadline(ctx, time.Now().Add(timeout))
 	}
-	if a.Connection != nil {
-		a.Connection.Close()
+	conversation, err := a.NewConversationContext(ctx)
+	if err != nil {
+		stop()
+		return nil, err
+	}
+	conversation.stop = stop
+	return conversation, nil
+}
+
+// NewConversationContext starts a new conversation that ends once ctx is done, without affecting the Assistant or any other conversation, it's the caller's job to close it
+// Every conversation shares the Assistant's connection, which is dialled by the first one, and cancelling ctx gives up on dialling it
+func (a *Assistant) NewConversationContext(ctx context.Context) (*Conversation, error) {
+	err := a.GCPAuth.Error()
+	if err != nil {
+		return nil, err
 	}
-	if a.GCPAuth != nil {
-		if a.GCPAuth.OauthSrv != nil {
-			a.GCPAuth.OauthSrv.Shutdown(context.Background())
-		}
+
+	if err := a.connect(ctx); err != nil {
+		return nil, err
 	}
+
+	return &Conversation{
+		Assistant: a,
+		Context:   ctx,
+	}, nil
 }
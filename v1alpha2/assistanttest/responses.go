@@ -0,0 +1,50 @@
+package assistanttest
+
+import (
+	gassist "google.golang.org/genproto/googleapis/assistant/embedded/v1alpha2"
+)
+
+// TextResponse returns a dialog state message carrying display text
+func TextResponse(text string) *gassist.AssistResponse {
+	return &gassist.AssistResponse{
+		DialogStateOut: &gassist.DialogStateOut{
+			SupplementalDisplayText: text,
+			MicrophoneMode:          gassist.DialogStateOut_CLOSE_MICROPHONE,
+		},
+	}
+}
+
+// AudioResponse returns a message carrying a chunk of spoken audio
+func AudioResponse(audioData []byte) *gassist.AssistResponse {
+	return &gassist.AssistResponse{
+		AudioOut: &gassist.AudioOut{AudioData: audioData},
+	}
+}
+
+// SpeechResponse returns a message carrying a single speech recognition result
+func SpeechResponse(transcript string, stability float32) *gassist.AssistResponse {
+	return &gassist.AssistResponse{
+		SpeechResults: []*gassist.SpeechRecognitionResult{
+			{Transcript: transcript, Stability: stability},
+		},
+	}
+}
+
+// ScreenOutResponse returns a message carrying an HTML visual answer
+func ScreenOutResponse(html string) *gassist.AssistResponse {
+	return &gassist.AssistResponse{
+		ScreenOut: &gassist.ScreenOut{Format: gassist.ScreenOut_HTML, Data: []byte(html)},
+	}
+}
+
+// DeviceActionResponse returns a message carrying a JSON device request
+func DeviceActionResponse(deviceRequestJSON string) *gassist.AssistResponse {
+	return &gassist.AssistResponse{
+		DeviceAction: &gassist.DeviceAction{DeviceRequestJson: deviceRequestJSON},
+	}
+}
+
+// EndOfUtteranceResponse returns a message signalling that the Assistant has stopped listening
+func EndOfUtteranceResponse() *gassist.AssistResponse {
+	return &gassist.AssistResponse{EventType: gassist.AssistResponse_END_OF_UTTERANCE}
+}
@@ -0,0 +1,262 @@
+// Package assistanttest provides a scriptable, in-memory Google Assistant server for testing code built on the assistant package
+package assistanttest
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	assistant "github.com/JoshuaDoes/google-assistant/v1alpha2"
+	"golang.org/x/oauth2"
+	gassist "google.golang.org/genproto/googleapis/assistant/embedded/v1alpha2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+)
+
+// Turn holds a canned response to a single query
+type Turn struct {
+	TextQuery  string //Text query to match, ignoring surrounding whitespace
+	AudioBytes int    //Amount of audio to accept before ending the utterance, a turn with a non-zero value matches audio queries instead of text queries
+
+	Responses         []*gassist.AssistResponse //Messages to stream back, in order
+	ConversationState []byte                    //Conversation state to return in the final dialog state, if any
+	Err               error                     //Error to end the stream with after the responses, if any
+	Delay             time.Duration             //How long to wait before sending each response, to simulate a slow Assistant
+
+	Repeat bool //Whether the turn may be matched more than once
+}
+
+// Server holds a fake EmbeddedAssistantServer listening on an in-process connection
+type Server struct {
+	gassist.UnimplementedEmbeddedAssistantServer
+
+	mutex    sync.Mutex
+	turns    []*Turn
+	requests []*gassist.AssistConfig
+	audioIn  [][]byte
+
+	listener   *bufconn.Listener
+	grpcServer *grpc.Server
+}
+
+// NewServer starts a new fake server, it's the caller's job to close it
+func NewServer() *Server {
+	s := &Server{
+		listener:   bufconn.Listen(1024 * 1024),
+		grpcServer: grpc.NewServer(),
+	}
+	gassist.RegisterEmbeddedAssistantServer(s.grpcServer, s)
+	go s.grpcServer.Serve(s.listener)
+	return s
+}
+
+// Close stops the server and drops every open stream
+func (s *Server) Close() {
+	s.grpcServer.Stop()
+	s.listener.Close()
+}
+
+// AddTurn queues a canned turn to be matched against incoming queries
+func (s *Server) AddTurn(turn *Turn) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.turns = append(s.turns, turn)
+}
+
+// AddTextTurn queues a canned reply to a text query, answered with the given display text and conversation state
+func (s *Server) AddTextTurn(textQuery, textResponse string, conversationState []byte) {
+	s.AddTurn(&Turn{
+		TextQuery:         textQuery,
+		Responses:         []*gassist.AssistResponse{TextResponse(textResponse)},
+		ConversationState: conversationState,
+	})
+}
+
+// AddAudioTurn queues a canned reply to an audio query, ending the utterance after audioBytes bytes of audio have been received
+func (s *Server) AddAudioTurn(audioBytes int, transcript, textResponse string, audioOut []byte, conversationState []byte) {
+	s.AddTurn(&Turn{
+		AudioBytes: audioBytes,
+		Responses: []*gassist.AssistResponse{
+			SpeechResponse(transcript, 1.0),
+			TextResponse(textResponse),
+			AudioResponse(audioOut),
+		},
+		ConversationState: conversationState,
+	})
+}
+
+// Requests returns the configuration of every query received so far, in order
+func (s *Server) Requests() []*gassist.AssistConfig {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]*gassist.AssistConfig(nil), s.requests...)
+}
+
+// AudioIn returns the audio received for every audio query so far, in order
+func (s *Server) AudioIn() [][]byte {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([][]byte(nil), s.audioIn...)
+}
+
+// Pending returns the amount of turns that haven't been matched yet, ignoring repeating turns
+func (s *Server) Pending() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	pending := 0
+	for _, turn := range s.turns {
+		if !turn.Repeat {
+			pending++
+		}
+	}
+	return pending
+}
+
+// DialOptions returns the gRPC dial options needed to reach the server
+func (s *Server) DialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return s.listener.DialContext(ctx)
+		}),
+	}
+}
+
+// Connect points an existing Assistant at the server
+func (s *Server) Connect(a *assistant.Assistant) {
+	a.SetEndpoint("bufnet", true, s.DialOptions()...)
+}
+
+// NewAssistant returns an Assistant that is already signed in and connected to the server
+func (s *Server) NewAssistant() (*assistant.Assistant, error) {
+	oauthToken := &oauth2.Token{AccessToken: "assistanttest", TokenType: "Bearer"}
+	a, err := assistant.NewAssistant(nil, oauthToken, nil, "", "en-US",
+		assistant.NewDevice("assistanttest-device", "assistanttest-model"),
+		assistant.NewAudioSettings(gassist.AudioInConfig_LINEAR16, gassist.AudioOutConfig_LINEAR16, 16000, 16000, 100),
+	)
+	if err != nil {
+		return nil, err
+	}
+	s.Connect(&a)
+	return &a, nil
+}
+
+// Assist implements gassist.EmbeddedAssistantServer
+func (s *Server) Assist(stream gassist.EmbeddedAssistant_AssistServer) error {
+	request, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	config := request.GetConfig()
+	if config == nil {
+		return status.Error(codes.InvalidArgument, "first request must be a config")
+	}
+
+	s.mutex.Lock()
+	s.requests = append(s.requests, config)
+	s.mutex.Unlock()
+
+	if config.GetAudioInConfig() != nil {
+		return s.assistAudio(stream)
+	}
+
+	turn := s.match(func(turn *Turn) bool {
+		return turn.AudioBytes == 0 && strings.TrimSpace(turn.TextQuery) == strings.TrimSpace(config.GetTextQuery())
+	})
+	if turn == nil {
+		return status.Errorf(codes.NotFound, "no turn scripted for text query %q", config.GetTextQuery())
+	}
+	return s.respond(stream, turn)
+}
+
+func (s *Server) assistAudio(stream gassist.EmbeddedAssistant_AssistServer) error {
+	turn := s.match(func(turn *Turn) bool {
+		return turn.AudioBytes > 0
+	})
+	if turn == nil {
+		return status.Error(codes.NotFound, "no turn scripted for audio query")
+	}
+
+	audioIn := make([]byte, 0)
+	for len(audioIn) < turn.AudioBytes {
+		request, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		audioIn = append(audioIn, request.GetAudioIn()...)
+	}
+
+	s.mutex.Lock()
+	s.audioIn = append(s.audioIn, audioIn)
+	s.mutex.Unlock()
+
+	if err := stream.Send(EndOfUtteranceResponse()); err != nil {
+		return err
+	}
+	return s.respond(stream, turn)
+}
+
+// match removes and returns the first queued turn accepted by matches, or nil if there isn't one
+func (s *Server) match(matches func(turn *Turn) bool) *Turn {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i, turn := range s.turns {
+		if matches(turn) {
+			if !turn.Repeat {
+				s.turns = append(s.turns[:i], s.turns[i+1:]...)
+			}
+			return turn
+		}
+	}
+	return nil
+}
+
+func (s *Server) respond(stream gassist.EmbeddedAssistant_AssistServer, turn *Turn) error {
+	responses := turn.Responses
+	if turn.ConversationState != nil {
+		responses = withConversationState(responses, turn.ConversationState)
+	}
+	for _, response := range responses {
+		if turn.Delay > 0 {
+			select {
+			case <-time.After(turn.Delay):
+			case <-stream.Context().Done():
+				return stream.Context().Err()
+			}
+		}
+		if err := stream.Send(response); err != nil {
+			return err
+		}
+	}
+	return turn.Err
+}
+
+// withConversationState returns a copy of responses where the last dialog state carries conversationState, adding one if needed
+func withConversationState(responses []*gassist.AssistResponse, conversationState []byte) []*gassist.AssistResponse {
+	copied := make([]*gassist.AssistResponse, len(responses))
+	last := -1
+	for i, response := range responses {
+		copied[i] = response
+		if response.GetDialogStateOut() != nil {
+			last = i
+		}
+	}
+	if last < 0 {
+		return append(copied, &gassist.AssistResponse{
+			DialogStateOut: &gassist.DialogStateOut{ConversationState: conversationState},
+		})
+	}
+
+	response := proto.Clone(copied[last]).(*gassist.AssistResponse)
+	response.DialogStateOut.ConversationState = conversationState
+	copied[last] = response
+	return copied
+}
@@ -0,0 +1,399 @@
+package assistanttest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	assistant "github.com/JoshuaDoes/google-assistant/v1alpha2"
+	gassist "google.golang.org/genproto/googleapis/assistant/embedded/v1alpha2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newConversation(t *testing.T, s *Server) *assistant.Conversation {
+	t.Helper()
+	a, err := s.NewAssistant()
+	if err != nil {
+		t.Fatalf("error creating assistant: %v", err)
+	}
+	t.Cleanup(a.Close)
+
+	conversation, err := a.NewConversation(0)
+	if err != nil {
+		t.Fatalf("error starting conversation: %v", err)
+	}
+	t.Cleanup(conversation.Close)
+	return conversation
+}
+
+func TestTextTurn(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.AddTextTurn("what time is it", "It's noon", []byte("state"))
+	conversation := newConversation(t, s)
+
+	response, err := conversation.RequestTransportText().QueryResponse("  what time is it ")
+	if err != nil {
+		t.Fatalf("error querying: %v", err)
+	}
+	if response.Text != "It's noon" {
+		t.Errorf("got text %q, want %q", response.Text, "It's noon")
+	}
+	if string(response.ConversationState) != "state" {
+		t.Errorf("got conversation state %q, want %q", response.ConversationState, "state")
+	}
+
+	requests := s.Requests()
+	if len(requests) != 1 || requests[0].GetTextQuery() != "  what time is it " {
+		t.Errorf("got requests %v, want the single text query", requests)
+	}
+	if s.Pending() != 0 {
+		t.Errorf("got %d pending turns, want 0", s.Pending())
+	}
+}
+
+func TestAudioTurn(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	audioOut := []byte{1, 2, 3, 4}
+	s.AddAudioTurn(300, "turn on the lights", "OK", audioOut, nil)
+	conversation := newConversation(t, s)
+
+	session := conversation.RequestVoiceSession()
+	if err := session.Start(); err != nil {
+		t.Fatalf("error starting voice session: %v", err)
+	}
+	for written := 0; ; written += 100 {
+		if _, err := session.Write(make([]byte, 100)); err != nil {
+			if written < 300 {
+				t.Fatalf("error writing audio after %d bytes: %v", written, err)
+			}
+			break
+		}
+	}
+	select {
+	case <-session.EndOfUtterance():
+	default:
+		t.Errorf("the utterance didn't end")
+	}
+
+	audio, err := io.ReadAll(session)
+	if err != nil {
+		t.Fatalf("error reading audio: %v", err)
+	}
+	if !bytes.Equal(audio, audioOut) {
+		t.Errorf("got audio %v, want %v", audio, audioOut)
+	}
+	if transcript, _ := session.Transcript(); transcript != "turn on the lights" {
+		t.Errorf("got transcript %q, want %q", transcript, "turn on the lights")
+	}
+
+	audioIn := s.AudioIn()
+	if len(audioIn) != 1 || len(audioIn[0]) != 300 {
+		t.Errorf("server received %d audio queries, want 1 of 300 bytes", len(audioIn))
+	}
+	if s.Requests()[0].GetAudioInConfig() == nil {
+		t.Errorf("voice session didn't send an audio config")
+	}
+}
+
+func TestAudioTurnCancelWithoutReading(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	responses := make([]*gassist.AssistResponse, 0)
+	for i := 0; i < 1000; i++ {
+		responses = append(responses, AudioResponse([]byte{1}))
+	}
+	s.AddTurn(&Turn{AudioBytes: 100, Responses: responses, Delay: time.Millisecond})
+	a, err := s.NewAssistant()
+	if err != nil {
+		t.Fatalf("error creating assistant: %v", err)
+	}
+	conversation, err := a.NewConversation(0)
+	if err != nil {
+		t.Fatalf("error starting conversation: %v", err)
+	}
+	defer conversation.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	session := conversation.RequestVoiceSession()
+	session.Events() //Never drained, like the audio
+	if err := session.StartContext(ctx); err != nil {
+		t.Fatalf("error starting voice session: %v", err)
+	}
+	if _, err := session.Write(make([]byte, 100)); err != nil {
+		t.Fatalf("error writing audio: %v", err)
+	}
+	<-session.EndOfUtterance()
+	time.Sleep(200 * time.Millisecond) //Let the answer fill up the queues, long before the Assistant has finished answering
+
+	cancel()
+	select {
+	case <-session.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("voice session didn't finish once its context was done")
+	}
+	if err := session.Wait(); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+
+	start := time.Now()
+	a.Close()
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("closing the assistant took %v, want the stream to have been released", elapsed)
+	}
+}
+
+func TestSynthesize(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.AddTextTurn("hello", "Hi", []byte("kept"))
+	first := strings.Repeat("one ", 40) + "end." //Too long to share a piece with the next sentence
+	second := strings.Repeat("two ", 40) + "end."
+	text := first + " " + second + " Done."
+	pieces := []string{first, second + " Done."}
+	for i, piece := range pieces {
+		s.AddTurn(&Turn{
+			TextQuery:         "repeat after me " + piece,
+			Responses:         []*gassist.AssistResponse{AudioResponse([]byte{byte(i), 1}), AudioResponse([]byte{byte(i), 2})},
+			ConversationState: []byte("synthesized"),
+		})
+	}
+	conversation := newConversation(t, s)
+	if _, err := conversation.RequestTransportText().Query("hello"); err != nil {
+		t.Fatalf("error querying: %v", err)
+	}
+
+	var audio bytes.Buffer
+	if err := conversation.Assistant.Synthesize(context.Background(), text, &audio); err != nil {
+		t.Fatalf("error synthesizing: %v", err)
+	}
+	if want := []byte{0, 1, 0, 2, 1, 1, 1, 2}; !bytes.Equal(audio.Bytes(), want) {
+		t.Errorf("got audio %v, want %v", audio.Bytes(), want)
+	}
+	if s.Pending() != 0 {
+		t.Errorf("got %d pending turns, want every piece to have been synthesized", s.Pending())
+	}
+
+	for _, request := range s.Requests()[1:] {
+		if dialogStateIn := request.GetDialogStateIn(); len(dialogStateIn.GetConversationState()) != 0 || !dialogStateIn.GetIsNewConversation() {
+			t.Errorf("%q was sent with dialog state %v, want a new conversation", request.GetTextQuery(), dialogStateIn)
+		}
+	}
+	if got := string(conversation.DialogState().ConversationState); got != "kept" {
+		t.Errorf("conversation ended up with conversation state %q, want %q", got, "kept")
+	}
+	if got := conversation.Assistant.DialogState.GetConversationState(); len(got) != 0 {
+		t.Errorf("assistant ended up with conversation state %q, want none", got)
+	}
+}
+
+func TestRepeat(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.AddTurn(&Turn{TextQuery: "hello", Responses: []*gassist.AssistResponse{TextResponse("Hi")}, Repeat: true})
+	s.AddTextTurn("bye", "Goodbye", nil)
+	conversation := newConversation(t, s)
+	transport := conversation.RequestTransportText()
+
+	for i := 0; i < 3; i++ {
+		if text, err := transport.Query("hello"); err != nil || text != "Hi" {
+			t.Fatalf("query %d got %q, %v, want the repeating turn", i+1, text, err)
+		}
+	}
+	if s.Pending() != 1 {
+		t.Errorf("got %d pending turns, want 1 as repeating turns aren't counted", s.Pending())
+	}
+
+	if _, err := transport.Query("bye"); err != nil {
+		t.Fatalf("error querying: %v", err)
+	}
+	_, err := transport.Query("bye")
+	var requestErr *assistant.RequestError
+	if !errors.As(err, &requestErr) || requestErr.Code != codes.NotFound {
+		t.Errorf("got %v for a turn that was already matched, want NotFound", err)
+	}
+}
+
+func TestErr(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.AddTurn(&Turn{TextQuery: "hello", Err: status.Error(codes.ResourceExhausted, "quota exceeded")})
+	conversation := newConversation(t, s)
+
+	_, err := conversation.RequestTransportText().Query("hello")
+	if !errors.Is(err, assistant.ErrQuotaExceeded) {
+		t.Errorf("got %v, want ErrQuotaExceeded", err)
+	}
+	var requestErr *assistant.RequestError
+	if !errors.As(err, &requestErr) || requestErr.Code != codes.ResourceExhausted {
+		t.Errorf("got %v, want a RequestError with ResourceExhausted", err)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.AddTurn(&Turn{TextQuery: "hello", Err: status.Error(codes.Unavailable, "try again")})
+	s.AddTextTurn("hello", "Hi", nil)
+	conversation := newConversation(t, s)
+	conversation.Assistant.RetryPolicy = &assistant.RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		RetryableCodes: []codes.Code{codes.Unavailable},
+	}
+
+	if text, err := conversation.RequestTransportText().Query("hello"); err != nil || text != "Hi" {
+		t.Fatalf("got %q, %v, want the query to succeed once it was sent again", text, err)
+	}
+	if requests := len(s.Requests()); requests != 2 {
+		t.Errorf("query was sent %d times, want 2", requests)
+	}
+}
+
+func TestNoRetryAfterDialogState(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.AddTurn(&Turn{
+		TextQuery: "turn on the lights",
+		Responses: []*gassist.AssistResponse{TextResponse("OK")},
+		Err:       status.Error(codes.Unavailable, "dropped after answering"),
+	})
+	s.AddTextTurn("turn on the lights", "OK", nil)
+	conversation := newConversation(t, s)
+	conversation.Assistant.RetryPolicy = &assistant.RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		RetryableCodes: []codes.Code{codes.Unavailable},
+	}
+
+	_, err := conversation.RequestTransportText().Query("turn on the lights")
+	var requestErr *assistant.RequestError
+	if !errors.As(err, &requestErr) || requestErr.Code != codes.Unavailable {
+		t.Errorf("got %v, want the error the stream ended with", err)
+	}
+	if requests := len(s.Requests()); requests != 1 {
+		t.Errorf("query was sent %d times, want 1 as the Assistant had already answered it", requests)
+	}
+	if s.Pending() != 1 {
+		t.Errorf("got %d pending turns, want 1", s.Pending())
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	tests := []struct {
+		name     string
+		timeouts assistant.Timeouts
+		delay    time.Duration
+		want     error
+	}{
+		{"first response", assistant.Timeouts{FirstResponse: 50 * time.Millisecond}, time.Second, assistant.ErrFirstResponseTimeout},
+		{"idle", assistant.Timeouts{Idle: 50 * time.Millisecond}, 200 * time.Millisecond, assistant.ErrIdleTimeout},
+		{"turn", assistant.Timeouts{Turn: 100 * time.Millisecond}, time.Second, assistant.ErrTurnTimeout},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := NewServer()
+			defer s.Close()
+			s.AddTurn(&Turn{
+				TextQuery: "hello",
+				Responses: []*gassist.AssistResponse{TextResponse("Hi"), AudioResponse([]byte{1})},
+				Delay:     test.delay,
+			})
+			conversation := newConversation(t, s)
+			conversation.Assistant.Timeouts = test.timeouts
+			conversation.Assistant.RetryPolicy = &assistant.NoRetryPolicy
+
+			_, err := conversation.RequestTransportText().Query("hello")
+			if !errors.Is(err, test.want) {
+				t.Fatalf("got %v, want %v", err, test.want)
+			}
+			var timeoutErr *assistant.TimeoutError
+			if !errors.As(err, &timeoutErr) {
+				t.Errorf("got %v, want a TimeoutError", err)
+			}
+			if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, assistant.ErrDeadlineExceeded) {
+				t.Errorf("got %v, want it to match context.DeadlineExceeded and ErrDeadlineExceeded", err)
+			}
+		})
+	}
+}
+
+func TestTurnTimeoutNotRetried(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.AddTurn(&Turn{TextQuery: "hello", Responses: []*gassist.AssistResponse{TextResponse("Hi")}, Delay: time.Second, Repeat: true})
+	conversation := newConversation(t, s)
+	conversation.Assistant.Timeouts = assistant.Timeouts{Turn: 100 * time.Millisecond}
+	conversation.Assistant.RetryPolicy = &assistant.RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: time.Millisecond,
+		RetryableCodes: []codes.Code{codes.DeadlineExceeded},
+	}
+
+	_, err := conversation.RequestTransportText().Query("hello")
+	if !errors.Is(err, assistant.ErrTurnTimeout) {
+		t.Fatalf("got %v, want ErrTurnTimeout", err)
+	}
+	if requests := len(s.Requests()); requests != 1 {
+		t.Errorf("query was sent %d times, want 1 as the turn's time was already spent", requests)
+	}
+}
+
+func TestTurnTimeoutAcrossRetries(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.AddTurn(&Turn{TextQuery: "hello", Responses: []*gassist.AssistResponse{TextResponse("Hi")}, Delay: time.Second, Repeat: true})
+	conversation := newConversation(t, s)
+	conversation.Assistant.Timeouts = assistant.Timeouts{FirstResponse: 40 * time.Millisecond, Turn: 300 * time.Millisecond}
+	conversation.Assistant.RetryPolicy = &assistant.RetryPolicy{
+		MaxAttempts:    100,
+		InitialBackoff: time.Millisecond,
+		RetryableCodes: []codes.Code{codes.DeadlineExceeded},
+	}
+
+	start := time.Now()
+	_, err := conversation.RequestTransportText().Query("hello")
+	if !errors.Is(err, assistant.ErrDeadlineExceeded) {
+		t.Fatalf("got %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second*2 {
+		t.Errorf("turn took %v, want it to end after 300ms no matter how many attempts were made", elapsed)
+	}
+	if requests := len(s.Requests()); requests < 2 || requests >= 100 {
+		t.Errorf("query was sent %d times, want it retried until the turn ran out of time", requests)
+	}
+}
+
+func TestWithConversationState(t *testing.T) {
+	responses := []*gassist.AssistResponse{AudioResponse([]byte{1})}
+	withState := withConversationState(responses, []byte("added"))
+	if len(withState) != 2 || string(withState[1].GetDialogStateOut().GetConversationState()) != "added" {
+		t.Errorf("got %v, want a dialog state carrying the conversation state to be added", withState)
+	}
+	if len(responses) != 1 {
+		t.Errorf("original responses were modified")
+	}
+
+	first, last := TextResponse("first"), TextResponse("last")
+	responses = []*gassist.AssistResponse{first, AudioResponse([]byte{1}), last}
+	withState = withConversationState(responses, []byte("replaced"))
+	if len(withState) != 3 {
+		t.Fatalf("got %d responses, want 3", len(withState))
+	}
+	if withState[0].GetDialogStateOut().GetConversationState() != nil {
+		t.Errorf("conversation state was added to an earlier dialog state")
+	}
+	if dialogStateOut := withState[2].GetDialogStateOut(); string(dialogStateOut.GetConversationState()) != "replaced" || dialogStateOut.SupplementalDisplayText != "last" {
+		t.Errorf("got last dialog state %v, want the last dialog state carrying the conversation state", dialogStateOut)
+	}
+	if last.GetDialogStateOut().GetConversationState() != nil {
+		t.Errorf("original dialog state was modified")
+	}
+}
@@ -1,6 +1,8 @@
 package assistant
 
 import (
+	"fmt"
+
 	gassist "google.golang.org/genproto/googleapis/assistant/embedded/v1alpha2"
 )
 
@@ -33,3 +35,33 @@ func NewAudioSettings(audioInEncoding gassist.AudioInConfig_Encoding, audioOutEn
 		AudioOutVolumePercentage: audioOutVolumePercentage,
 	}
 }
+
+// Validate returns ErrInvalidAudioConfig if Google would reject the audio settings
+func (s *AudioSettings) Validate() error {
+	return s.validate(true)
+}
+
+// validate checks the audio output settings, as well as the audio input settings when sending audio
+func (s *AudioSettings) validate(audioIn bool) error {
+	if s == nil {
+		return fmt.Errorf("%w: no audio settings", ErrInvalidAudioConfig)
+	}
+	if audioIn {
+		if s.AudioInEncoding == gassist.AudioInConfig_ENCODING_UNSPECIFIED {
+			return fmt.Errorf("%w: audio in encoding must be specified", ErrInvalidAudioConfig)
+		}
+		if s.AudioInSampleRateHertz < 16000 || s.AudioInSampleRateHertz > 24000 {
+			return fmt.Errorf("%w: audio in sample rate must be 16000-24000 Hz, got %d", ErrInvalidAudioConfig, s.AudioInSampleRateHertz)
+		}
+	}
+	if s.AudioOutEncoding == gassist.AudioOutConfig_ENCODING_UNSPECIFIED {
+		return fmt.Errorf("%w: audio out encoding must be specified", ErrInvalidAudioConfig)
+	}
+	if s.AudioOutSampleRateHertz < 16000 || s.AudioOutSampleRateHertz > 24000 {
+		return fmt.Errorf("%w: audio out sample rate must be 16000-24000 Hz, got %d", ErrInvalidAudioConfig, s.AudioOutSampleRateHertz)
+	}
+	if s.AudioOutVolumePercentage < 1 || s.AudioOutVolumePercentage > 100 {
+		return fmt.Errorf("%w: audio out volume must be 1-100%%, got %d", ErrInvalidAudioConfig, s.AudioOutVolumePercentage)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package assistant
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	gassist "google.golang.org/genproto/googleapis/assistant/embedded/v1alpha2"
+	"google.golang.org/grpc/keepalive"
+)
+
+// DefaultKeepalive is used by an Assistant without keepalive parameters of its own, pinging Google only while a turn is in flight
+var DefaultKeepalive = keepalive.ClientParameters{
+	Time:    time.Minute,
+	Timeout: time.Second * 20,
+}
+
+// DefaultCloseGracePeriod is how long Close waits for in-flight turns by default
+const DefaultCloseGracePeriod = time.Second * 5
+
+// connection holds the state of the connection shared by every conversation of an Assistant
+type connection struct {
+	mutex      sync.Mutex
+	streams    int           //Streams that haven't finished yet
+	generation int           //Changes every time the connection is closed
+	drained    chan struct{} //Closed once every stream has finished while closing
+}
+
+// connection returns the state of the shared connection
+func (a *Assistant) connection() *connection {
+	if a.conn == nil {
+		a.conn = &connection{} //Only for an Assistant that wasn't created with NewAssistant
+	}
+	return a.conn
+}
+
+// connect dials the shared connection if there isn't one yet, giving up once ctx is done
+func (a *Assistant) connect(ctx context.Context) error {
+	conn := a.connection()
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	return a.connectLocked(ctx)
+}
+
+func (a *Assistant) connectLocked(ctx context.Context) error {
+	if a.Connection != nil {
+		return nil
+	}
+	connection, err := a.newConnection(ctx) //Only dialling is bound to ctx, the connection outlives every conversation
+	if err != nil {
+		return newRequestError("connecting", err)
+	}
+	a.Connection = connection
+	a.GoogleAssistant = gassist.NewEmbeddedAssistantClient(connection)
+	return nil
+}
+
+// openStream opens a new stream on the shared connection, dialling it again if it was closed
+// The returned function must be called once the stream has finished, so Close knows when it's safe to tear the connection down
+func (a *Assistant) openStream(ctx context.Context) (gassist.EmbeddedAssistant_AssistClient, func(), error) {
+	conn := a.connection()
+	conn.mutex.Lock()
+	if conn.drained != nil {
+		conn.mutex.Unlock()
+		return nil, nil, newRequestError("opening stream", ErrClosed)
+	}
+	if err := a.connectLocked(ctx); err != nil {
+		conn.mutex.Unlock()
+		return nil, nil, err
+	}
+	conn.streams++
+	generation := conn.generation
+	client := a.GoogleAssistant
+	conn.mutex.Unlock()
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			conn.mutex.Lock()
+			defer conn.mutex.Unlock()
+			if conn.generation != generation {
+				return //Torn down along with the connection it was opened on
+			}
+			conn.streams--
+			if conn.streams == 0 && conn.drained != nil {
+				close(conn.drained)
+			}
+		})
+	}
+
+	stream, err := client.Assist(ctx)
+	if err != nil {
+		release()
+		return nil, nil, newRequestError("opening stream", err)
+	}
+	return stream, release, nil
+}
+
+// Close waits up to CloseGracePeriod for in-flight turns to finish, then closes the connection to the Assistant and cleans up all resources, except for conversations which must be handled by the caller
+// The Assistant may still be used afterwards, which dials a new connection
+func (a *Assistant) Close() {
+	conn := a.connection()
+	conn.mutex.Lock()
+	drained := make(chan struct{})
+	if conn.streams == 0 {
+		close(drained)
+	}
+	conn.drained = drained
+	conn.mutex.Unlock()
+
+	gracePeriod := a.CloseGracePeriod
+	if gracePeriod == 0 {
+		gracePeriod = DefaultCloseGracePeriod
+	}
+	timer := time.NewTimer(gracePeriod)
+	select {
+	case <-drained:
+	case <-timer.C: //Give up on the turns that are still in flight
+		a.logf("assistant: closing with turns still in flight after %v", gracePeriod)
+	}
+	timer.Stop()
+
+	conn.mutex.Lock()
+	if a.Canceler != nil {
+		a.Canceler()
+	}
+	if a.Connection != nil {
+		a.Connection.Close()
+		a.Connection = nil
+	}
+	conn.streams = 0 //Streams still in flight were torn down along with the connection
+	conn.generation++
+	conn.drained = nil
+	conn.mutex.Unlock()
+
+	if a.GCPAuth != nil {
+		if srv := a.GCPAuth.oauthServer(); srv != nil {
+			srv.Shutdown(context.Background())
+		}
+	}
+}
@@ -1,10 +1,14 @@
 package assistant
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"sync"
+	"time"
 
 	gassist "google.golang.org/genproto/googleapis/assistant/embedded/v1alpha2"
+	"google.golang.org/protobuf/proto"
 )
 
 // Conversation holds a Google Assistant conversation
@@ -12,23 +16,125 @@ type Conversation struct {
 	Assistant    *Assistant //A pointer to the assistant, because we don't like high memory usage with multiple conversations now do we?
 	AssistClient gassist.EmbeddedAssistant_AssistClient
 	Running      bool
+	Context      context.Context //Ends every turn of the conversation once done, defaults to the Assistant's context
+
+	cancel context.CancelCauseFunc //Ends the current stream
+	stop   context.CancelFunc      //Ends the conversation, if it was created with a timeout by NewConversation
+
+	mutex       sync.Mutex
+	dialogState *gassist.DialogStateIn //Carried over between the turns of this conversation only, starting from the Assistant's defaults
+	volume      int32
 }
 
 // Refresh initializes a new client stream, must be called before every query
 func (c *Conversation) Refresh() error {
-	if c.Running {
-		c.AssistClient.CloseSend()
-		c.Running = false
-	}
-	assistClient, err := c.Assistant.GoogleAssistant.Assist(c.Assistant.Context)
+	return c.RefreshContext(c.context())
+}
+
+// RefreshContext initializes a new client stream that is torn down once ctx is done, must be called before every query
+func (c *Conversation) RefreshContext(ctx context.Context) error {
+	return c.refresh(ctx, c.Assistant.Timeouts.Turn)
+}
+
+// refresh initializes a new client stream, ending it once turnTimeout expires if it isn't 0
+func (c *Conversation) refresh(ctx context.Context, turnTimeout time.Duration) error {
+	c.closeStream()
+	turnCtx, cancelTurn := c.turnContext(ctx, turnTimeout)
+	assistClient, release, err := c.Assistant.openStream(turnCtx)
 	if err != nil {
+		cancelTurn(nil)
 		return err
 	}
-	c.AssistClient = assistClient
+	cancel := func(cause error) {
+		cancelTurn(cause)
+		release()
+	}
+	c.AssistClient = &timedStream{
+		EmbeddedAssistant_AssistClient: assistClient,
+		ctx:                            turnCtx,
+		cancel:                         cancel,
+		release:                        release,
+		firstResponse:                  c.Assistant.Timeouts.FirstResponse,
+		idle:                           c.Assistant.Timeouts.Idle,
+	}
+	c.cancel = cancel
 	c.Running = true
 	return nil
 }
 
+// context returns the context every turn of the conversation is bound to
+func (c *Conversation) context() context.Context {
+	if c.Context != nil {
+		return c.Context
+	}
+	if c.Assistant.Context != nil {
+		return c.Assistant.Context
+	}
+	return context.Background()
+}
+
+// streamContext returns the context the current stream is torn down with
+func (c *Conversation) streamContext() context.Context {
+	if stream, ok := c.AssistClient.(*timedStream); ok {
+		return stream.ctx
+	}
+	return c.context()
+}
+
+// startTurn returns the context of a whole turn, which ends with ctx or once Timeouts.Turn expires, no matter how many attempts are made
+// Every attempt opens its stream with refresh(ctx, 0) so the turn isn't timed again
+func (c *Conversation) startTurn(ctx context.Context) (context.Context, context.CancelFunc) {
+	if timeout := c.Assistant.Timeouts.Turn; timeout > 0 {
+		return context.WithTimeoutCause(ctx, timeout, &TimeoutError{Err: ErrTurnTimeout, Timeout: timeout})
+	}
+	return context.WithCancel(ctx)
+}
+
+// keepTurn ends the turn started by startTurn along with the current stream, for turns that outlive the call that started them
+func (c *Conversation) keepTurn(endTurn context.CancelFunc) {
+	cancel := c.cancel
+	c.cancel = func(cause error) {
+		cancel(cause)
+		endTurn()
+	}
+}
+
+// turnContext returns the context of a single stream, which ends with either ctx, the conversation or the turn timeout
+func (c *Conversation) turnContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelCauseFunc) {
+	turnCtx, cancel := context.WithCancelCause(ctx)
+	conversationCtx := c.context()
+	stop := context.AfterFunc(conversationCtx, func() {
+		cancel(context.Cause(conversationCtx))
+	})
+
+	var timer *time.Timer
+	if timeout > 0 {
+		timer = time.AfterFunc(timeout, func() {
+			cancel(&TimeoutError{Err: ErrTurnTimeout, Timeout: timeout})
+		})
+	}
+
+	return turnCtx, func(cause error) {
+		stop()
+		if timer != nil {
+			timer.Stop()
+		}
+		cancel(cause)
+	}
+}
+
+// closeStream closes the current stream, if any
+func (c *Conversation) closeStream() {
+	if c.Running {
+		c.AssistClient.CloseSend()
+		c.Running = false
+	}
+	if c.cancel != nil {
+		c.cancel(nil)
+		c.cancel = nil
+	}
+}
+
 // RequestTransportAudio returns an audio query transport, which must be used for the remainder of this conversation
 func (c *Conversation) RequestTransportAudio() *TransportAudio {
 	return &TransportAudio{
@@ -45,13 +151,102 @@ func (c *Conversation) RequestTransportText() *TransportText {
 
 // Close closes the conversation
 func (c *Conversation) Close() {
-	if c.Running {
-		c.AssistClient.CloseSend()
-		c.Running = false
+	c.closeStream()
+	if c.stop != nil {
+		c.stop()
+	}
+}
+
+// DialogState returns a copy of the dialog state that will be sent with the next turn of this conversation
+func (c *Conversation) DialogState() *gassist.DialogStateIn {
+	return c.dialogStateIn()
+}
+
+// SetLanguageCode sets the language of the following turns of this conversation
+func (c *Conversation) SetLanguageCode(languageCode string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.stateLocked().LanguageCode = languageCode
+}
+
+// SetLocation sets where the device is for the following turns of this conversation, or nil to let Google work it out
+func (c *Conversation) SetLocation(location *gassist.DeviceLocation) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.stateLocked().DeviceLocation = location
+}
+
+// Volume returns the volume the Assistant speaks at in this conversation, which changes when the user asks for it to
+func (c *Conversation) Volume() int32 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.stateLocked()
+	return c.volume
+}
+
+// SetVolume sets the volume the Assistant speaks at in the following turns of this conversation, from 1 to 100
+func (c *Conversation) SetVolume(volumePercentage int32) error {
+	if volumePercentage < 1 || volumePercentage > 100 {
+		return fmt.Errorf("%w: audio out volume must be 1-100%%, got %d", ErrInvalidAudioConfig, volumePercentage)
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.stateLocked()
+	c.volume = volumePercentage
+	return nil
+}
+
+// stateLocked returns the dialog state of the conversation, starting it from the Assistant's defaults if needed
+func (c *Conversation) stateLocked() *gassist.DialogStateIn {
+	if c.dialogState == nil {
+		if c.Assistant.DialogState != nil {
+			c.dialogState = proto.Clone(c.Assistant.DialogState).(*gassist.DialogStateIn)
+		} else {
+			c.dialogState = &gassist.DialogStateIn{
+				LanguageCode:      c.Assistant.LanguageCode,
+				IsNewConversation: true,
+			}
+		}
+		if c.Assistant.AudioSettings != nil {
+			c.volume = c.Assistant.AudioSettings.AudioOutVolumePercentage
+		}
+	}
+	return c.dialogState
+}
+
+// dialogStateIn returns the dialog state to send with the next turn, which is copied so it can't change while being sent
+func (c *Conversation) dialogStateIn() *gassist.DialogStateIn {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return proto.Clone(c.stateLocked()).(*gassist.DialogStateIn)
+}
+
+func (c *Conversation) audioOutConfig() *gassist.AudioOutConfig {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.stateLocked()
+	return &gassist.AudioOutConfig{
+		Encoding:         c.Assistant.AudioSettings.AudioOutEncoding,
+		SampleRateHertz:  c.Assistant.AudioSettings.AudioOutSampleRateHertz,
+		VolumePercentage: c.volume,
+	}
+}
+
+// updateDialogState carries the Assistant's dialog state over to the next turn of this conversation
+func (c *Conversation) updateDialogState(dialogStateOut *gassist.DialogStateOut) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	dialogState := c.stateLocked()
+	dialogState.ConversationState = dialogStateOut.ConversationState
+	dialogState.IsNewConversation = false
+	if dialogStateOut.VolumePercentage != 0 {
+		c.volume = dialogStateOut.VolumePercentage
 	}
 }
 
 // TransportAudio holds a request for an audio query
+//
+// Deprecated: TransportAudio opens a new stream for every chunk written to it, use VoiceSession instead
 type TransportAudio struct {
 	SpeechRecognitionResult    string
 	SpeechRecognitionStability float32
@@ -71,18 +266,14 @@ func (r *TransportAudio) Start(finishedChan chan bool) error {
 						SampleRateHertz: r.Conversation.Assistant.AudioSettings.AudioInSampleRateHertz,
 					},
 				},
-				AudioOutConfig: &gassist.AudioOutConfig{
-					Encoding:         r.Conversation.Assistant.AudioSettings.AudioOutEncoding,
-					SampleRateHertz:  r.Conversation.Assistant.AudioSettings.AudioOutSampleRateHertz,
-					VolumePercentage: r.Conversation.Assistant.AudioSettings.AudioOutVolumePercentage,
-				},
-				DeviceConfig:  r.Conversation.Assistant.Device.DeviceConfig,
-				DialogStateIn: r.Conversation.Assistant.DialogState,
+				AudioOutConfig: r.Conversation.audioOutConfig(),
+				DeviceConfig:   r.Conversation.Assistant.Device.DeviceConfig,
+				DialogStateIn:  r.Conversation.dialogStateIn(),
 			},
 		},
 	})
 	if err != nil {
-		return err
+		return newRequestError("sending request", err)
 	}
 
 	for {
@@ -104,7 +295,10 @@ func (r *TransportAudio) Read(p []byte) (n int, err error) {
 		if err != nil {
 			//TODO: Send io.EOF if conversation ends instead of the read stream ending
 			r.Finished = true
-			return 0, err
+			if err == io.EOF {
+				return 0, err
+			}
+			return 0, newRequestError("getting response", err)
 		}
 
 		if response == nil {
@@ -112,8 +306,7 @@ func (r *TransportAudio) Read(p []byte) (n int, err error) {
 		}
 
 		if dialogStateOut := response.GetDialogStateOut(); dialogStateOut != nil {
-			r.Conversation.Assistant.DialogState.ConversationState = dialogStateOut.ConversationState
-			r.Conversation.Assistant.AudioSettings.AudioOutVolumePercentage = dialogStateOut.VolumePercentage
+			r.Conversation.updateDialogState(dialogStateOut)
 		}
 
 		if r.SpeechRecognitionStability != 1.0 {
@@ -143,8 +336,6 @@ func (r *TransportAudio) Read(p []byte) (n int, err error) {
 			return copy(p, audioOut.AudioData), nil
 		}
 	}
-
-	return 0, nil
 }
 
 // Write implements io.Writer and sends audio directly to Google, must be buffered at an unknown-required rate (32KB/s seems too slow but works in testing)
@@ -167,77 +358,146 @@ func (r *TransportAudio) Transcript() (transcript string, stability float32) {
 type TransportText struct {
 	TextQuery    string
 	TextResponse string
+	Response     *Response
+
+	ScreenMode gassist.ScreenOutConfig_ScreenMode //Set to ScreenOutConfig_PLAYING to receive visual answers in Response.ScreenOut
+	Debug      bool                               //Set to true to receive debug info in Response.DebugInfo
 
 	Conversation *Conversation
 }
 
 // Query returns the Assistant's response to a query as text
 func (r *TransportText) Query(textQuery string) (string, error) {
-	if url := r.Conversation.Assistant.GetAuthURL(); url != "" {
-		return "", fmt.Errorf("must re-authenticate again: %s", url)
-	}
-	r.Conversation.Refresh() //Initialize a new stream
-	r.TextQuery = textQuery
-	if err := r.send(textQuery); err != nil {
+	return r.QueryContext(r.Conversation.context(), textQuery)
+}
+
+// QueryContext returns the Assistant's response to a query as text, giving up on the turn once ctx is done
+func (r *TransportText) QueryContext(ctx context.Context, textQuery string) (string, error) {
+	response, err := r.QueryResponseContext(ctx, textQuery)
+	if err != nil {
 		return "", err
 	}
-	if err := r.recv(textQuery); err != nil {
-		return "", err
+	return response.Text, nil
+}
+
+// QueryResponse returns everything the Assistant returned in response to a query, including audio, visual answers and device actions
+func (r *TransportText) QueryResponse(textQuery string) (*Response, error) {
+	return r.QueryResponseContext(r.Conversation.context(), textQuery)
+}
+
+// QueryResponseContext returns everything the Assistant returned in response to a query, giving up on the turn once ctx is done
+func (r *TransportText) QueryResponseContext(ctx context.Context, textQuery string) (*Response, error) {
+	return r.query(ctx, textQuery, nil)
+}
+
+// QueryAudio returns everything the Assistant returned in response to a query, while also writing the spoken answer to w as it arrives
+// It only returns once Google has finished streaming the answer, and a failed turn isn't retried once audio has been written
+func (r *TransportText) QueryAudio(textQuery string, w io.Writer) (*Response, error) {
+	return r.QueryAudioContext(r.Conversation.context(), textQuery, w)
+}
+
+// QueryAudioContext is like QueryAudio, but gives up on the turn once ctx is done
+func (r *TransportText) QueryAudioContext(ctx context.Context, textQuery string, w io.Writer) (*Response, error) {
+	return r.query(ctx, textQuery, w)
+}
+
+// QueryAudioBytes returns the Assistant's spoken answer to a query, in the configured AudioOutEncoding
+func (r *TransportText) QueryAudioBytes(textQuery string) ([]byte, error) {
+	return r.QueryAudioBytesContext(r.Conversation.context(), textQuery)
+}
+
+// QueryAudioBytesContext is like QueryAudioBytes, but gives up on the turn once ctx is done
+func (r *TransportText) QueryAudioBytesContext(ctx context.Context, textQuery string) ([]byte, error) {
+	response, err := r.query(ctx, textQuery, nil)
+	if err != nil {
+		return nil, err
+	}
+	return response.AudioOut, nil
+}
+
+// query sends a text query and collects the response, writing the spoken answer to audioOut as it arrives if it isn't nil
+func (r *TransportText) query(ctx context.Context, textQuery string, audioOut io.Writer) (*Response, error) {
+	if err := r.Conversation.Assistant.checkAuth(); err != nil {
+		return nil, err
+	}
+	r.TextQuery = textQuery
+	ctx, endTurn := r.Conversation.startTurn(ctx)
+	defer endTurn()
+	err := r.Conversation.Assistant.retry(ctx, func() (bool, error) {
+		if err := r.Conversation.refresh(ctx, 0); err != nil { //Initialize a new stream
+			return true, err
+		}
+		r.Response = &Response{}
+		if err := r.send(textQuery); err != nil {
+			return true, err
+		}
+		return r.recv(audioOut)
+	})
+	if err != nil {
+		return nil, err
 	}
-	return r.TextResponse, nil
+	return r.Response, nil
 }
 
 func (r *TransportText) send(textQuery string) error {
+	if err := r.Conversation.Assistant.AudioSettings.validate(false); err != nil {
+		return err
+	}
+
+	config := &gassist.AssistConfig{
+		Type: &gassist.AssistConfig_TextQuery{
+			TextQuery: textQuery,
+		},
+		AudioOutConfig: r.Conversation.audioOutConfig(),
+		DeviceConfig:   r.Conversation.Assistant.Device.DeviceConfig,
+		DialogStateIn:  r.Conversation.dialogStateIn(),
+	}
+	if r.ScreenMode != gassist.ScreenOutConfig_SCREEN_MODE_UNSPECIFIED {
+		config.ScreenOutConfig = &gassist.ScreenOutConfig{ScreenMode: r.ScreenMode}
+	}
+	if r.Debug {
+		config.DebugConfig = &gassist.DebugConfig{ReturnDebugInfo: true}
+	}
+
 	err := r.Conversation.AssistClient.Send(&gassist.AssistRequest{
 		Type: &gassist.AssistRequest_Config{
-			Config: &gassist.AssistConfig{
-				Type: &gassist.AssistConfig_TextQuery{
-					TextQuery: textQuery,
-				},
-				AudioOutConfig: &gassist.AudioOutConfig{
-					Encoding:         r.Conversation.Assistant.AudioSettings.AudioOutEncoding,
-					SampleRateHertz:  r.Conversation.Assistant.AudioSettings.AudioOutSampleRateHertz,
-					VolumePercentage: r.Conversation.Assistant.AudioSettings.AudioOutVolumePercentage,
-				},
-				DeviceConfig:  r.Conversation.Assistant.Device.DeviceConfig,
-				DialogStateIn: r.Conversation.Assistant.DialogState,
-			},
+			Config: config,
 		},
 	})
-	if err != nil {
-		err = fmt.Errorf("error sending request: %v", err)
-	}
-	return err
+	return newRequestError("sending request", err)
 }
 
-func (r *TransportText) recv(textQuery string) error {
+// recv collects the response stream until the Assistant closes it after answering, and returns whether the query may be sent again
+// The dialog state may arrive before the spoken answer has finished, so only the end of the stream finishes the turn
+func (r *TransportText) recv(audioOut io.Writer) (retryable bool, err error) {
+	gotDialogState := false
+	wroteAudio := false
 	for {
 		response, err := r.Conversation.AssistClient.Recv()
 		if err != nil {
-			if err == io.EOF {
-				if err := r.send(textQuery); err != nil {
-					return fmt.Errorf("error re-sending request after EOF: %v", err)
-				}
-				response, err = r.Conversation.AssistClient.Recv()
-				if err != nil {
-					return fmt.Errorf("error getting response after re-sending request from EOF: %v", err)
-				}
-			} else {
-				return fmt.Errorf("error getting response: %v", err)
+			if err == io.EOF && gotDialogState {
+				break
 			}
+			return !gotDialogState && !wroteAudio, newRequestError("getting response", err) //The stream ending before an answer is retried as codes.Aborted
 		}
 
 		if response == nil {
-			return fmt.Errorf("nil response")
+			return !gotDialogState && !wroteAudio, newRequestError("getting response", ErrStreamClosed)
 		}
 
+		r.Response.add(response)
+		if audioData := response.GetAudioOut().GetAudioData(); audioOut != nil && len(audioData) > 0 {
+			wroteAudio = true
+			if _, err := audioOut.Write(audioData); err != nil {
+				r.Conversation.closeStream() //Nobody is listening to the rest of the answer
+				return false, fmt.Errorf("error writing audio: %w", err)
+			}
+		}
 		if dialogStateOut := response.GetDialogStateOut(); dialogStateOut != nil {
-			r.Conversation.Assistant.DialogState.ConversationState = dialogStateOut.ConversationState
-			r.Conversation.Assistant.DialogState.IsNewConversation = false
-			r.Conversation.Assistant.AudioSettings.AudioOutVolumePercentage = dialogStateOut.VolumePercentage
-			r.TextResponse = dialogStateOut.GetSupplementalDisplayText()
-			break
+			r.Conversation.updateDialogState(dialogStateOut)
+			r.TextResponse = r.Response.Text
+			gotDialogState = true
 		}
 	}
-	return nil
+	return false, nil
 }
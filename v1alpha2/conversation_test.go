@@ -0,0 +1,97 @@
+package assistant_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/JoshuaDoes/google-assistant/v1alpha2/assistanttest"
+)
+
+func TestConcurrentConversations(t *testing.T) {
+	languageCodes := []string{"en-US", "en-GB", "de-DE", "fr-FR", "ja-JP", "es-ES", "it-IT", "pt-BR"}
+	const turns = 3
+
+	s := assistanttest.NewServer()
+	defer s.Close()
+	for i := range languageCodes {
+		for j := 0; j < turns; j++ {
+			s.AddTextTurn(turnQuery(i, j), "OK", []byte(turnConversationState(i, j)))
+		}
+	}
+
+	a, err := s.NewAssistant()
+	if err != nil {
+		t.Fatalf("error creating assistant: %v", err)
+	}
+	defer a.Close()
+
+	var wg sync.WaitGroup
+	for i, languageCode := range languageCodes {
+		wg.Add(1)
+		go func(i int, languageCode string) {
+			defer wg.Done()
+			conversation, err := a.NewConversation(0)
+			if err != nil {
+				t.Errorf("error starting conversation %d: %v", i, err)
+				return
+			}
+			defer conversation.Close()
+			conversation.SetLanguageCode(languageCode)
+			if err := conversation.SetVolume(int32(10 + i)); err != nil {
+				t.Errorf("error setting volume of conversation %d: %v", i, err)
+				return
+			}
+
+			transport := conversation.RequestTransportText()
+			for j := 0; j < turns; j++ {
+				if _, err := transport.Query(turnQuery(i, j)); err != nil {
+					t.Errorf("error querying conversation %d turn %d: %v", i, j, err)
+					return
+				}
+			}
+			if got := string(conversation.DialogState().ConversationState); got != turnConversationState(i, turns-1) {
+				t.Errorf("conversation %d ended with conversation state %q, want %q", i, got, turnConversationState(i, turns-1))
+			}
+		}(i, languageCode)
+	}
+	wg.Wait()
+
+	requests := s.Requests()
+	if len(requests) != len(languageCodes)*turns {
+		t.Fatalf("server received %d requests, want %d", len(requests), len(languageCodes)*turns)
+	}
+	for _, request := range requests {
+		var i, j int
+		if _, err := fmt.Sscanf(request.GetTextQuery(), "conversation %d turn %d", &i, &j); err != nil {
+			t.Fatalf("unexpected query %q", request.GetTextQuery())
+		}
+
+		dialogStateIn := request.GetDialogStateIn()
+		if dialogStateIn.GetLanguageCode() != languageCodes[i] {
+			t.Errorf("%s was sent with language %q, want %q", turnQuery(i, j), dialogStateIn.GetLanguageCode(), languageCodes[i])
+		}
+		if volume := request.GetAudioOutConfig().GetVolumePercentage(); volume != int32(10+i) {
+			t.Errorf("%s was sent with volume %d, want %d", turnQuery(i, j), volume, 10+i)
+		}
+
+		wantState := ""
+		if j > 0 {
+			wantState = turnConversationState(i, j-1)
+		}
+		if got := string(dialogStateIn.GetConversationState()); got != wantState {
+			t.Errorf("%s was sent with conversation state %q, want %q", turnQuery(i, j), got, wantState)
+		}
+		if dialogStateIn.GetIsNewConversation() != (j == 0) {
+			t.Errorf("%s was sent with IsNewConversation %v, want %v", turnQuery(i, j), dialogStateIn.GetIsNewConversation(), j == 0)
+		}
+	}
+}
+
+func turnQuery(conversation, turn int) string {
+	return fmt.Sprintf("conversation %d turn %d", conversation, turn)
+}
+
+func turnConversationState(conversation, turn int) string {
+	return fmt.Sprintf("state of conversation %d after turn %d", conversation, turn)
+}
@@ -0,0 +1,95 @@
+package assistant
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/oauth2"
+)
+
+// ClientCredentials holds an OAuth2 client from a Google client secret file
+type ClientCredentials struct {
+	ClientID                string   `json:"client_id"`
+	ProjectID               string   `json:"project_id"`
+	AuthURI                 string   `json:"auth_uri"`
+	TokenURI                string   `json:"token_uri"`
+	AuthProviderX509CertURL string   `json:"auth_provider_x509_cert_url"`
+	ClientSecret            string   `json:"client_secret"`
+	RedirectUris            []string `json:"redirect_uris"`
+}
+
+// Token holds Google OAuth2 credentials, either an installed or web client secret file or a gcloud application default credentials file
+type Token struct {
+	Installed ClientCredentials `json:"installed"`
+	Web       ClientCredentials `json:"web"`
+
+	//Application default credentials, as written by gcloud auth application-default login
+	Type           string `json:"type"`
+	ClientID       string `json:"client_id"`
+	ClientSecret   string `json:"client_secret"`
+	RefreshToken   string `json:"refresh_token"`
+	QuotaProjectID string `json:"quota_project_id"`
+}
+
+// GetCredentialsFromFile loads credentials from a Google client secret or application default credentials file
+func GetCredentialsFromFile(fileCredentials string) (*Token, error) {
+	credentialsJSON, err := os.ReadFile(fileCredentials)
+	if err != nil {
+		return nil, err
+	}
+	return ParseCredentials(credentialsJSON)
+}
+
+// ParseCredentials parses credentials from the contents of a Google client secret or application default credentials file
+func ParseCredentials(credentialsJSON []byte) (*Token, error) {
+	token := &Token{}
+	if err := json.Unmarshal(credentialsJSON, token); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case token.Installed.ClientID != "", token.Web.ClientID != "":
+	case token.Type == "authorized_user" && token.ClientID != "":
+		if token.RefreshToken == "" {
+			return nil, errors.New("application default credentials have no refresh token")
+		}
+	case token.Type != "":
+		return nil, fmt.Errorf("unsupported credentials type %q", token.Type)
+	default:
+		return nil, errors.New("no OAuth2 client found in credentials")
+	}
+	return token, nil
+}
+
+// OauthToken returns the OAuth2 token stored in application default credentials, or nil for client secret files
+func (t *Token) OauthToken() *oauth2.Token {
+	if t == nil || t.RefreshToken == "" {
+		return nil
+	}
+	return &oauth2.Token{RefreshToken: t.RefreshToken}
+}
+
+// client returns the OAuth2 client, whichever format it was loaded from
+func (t *Token) client() *ClientCredentials {
+	switch {
+	case t.Installed.ClientID != "":
+		return &t.Installed
+	case t.Web.ClientID != "":
+		return &t.Web
+	}
+	return &ClientCredentials{
+		ClientID:     t.ClientID,
+		ClientSecret: t.ClientSecret,
+		TokenURI:     "https://oauth2.googleapis.com/token",
+	}
+}
+
+// redirectURL returns the first redirect URL of the OAuth2 client, or a loopback address if there isn't one
+func (t *Token) redirectURL() string {
+	if client := t.client(); len(client.RedirectUris) > 0 {
+		return client.RedirectUris[0]
+	}
+	return "http://localhost"
+}
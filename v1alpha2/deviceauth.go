@@ -0,0 +1,55 @@
+package assistant
+
+import (
+	"context"
+	"time"
+)
+
+// DeviceCode holds what the user needs to approve signing in from another device
+type DeviceCode struct {
+	UserCode        string    //Code the user must enter at VerificationURL
+	VerificationURL string    //Page where the user approves signing in
+	Expiry          time.Time //When the user code stops being accepted
+}
+
+// InitializeDevice starts an OAuth2 device authorization grant for devices without a usable browser, replacing any loopback server started by Initialize
+// The returned code must be shown to the user, while the token endpoint is polled in the background until they approve, deny or ctx is done
+// Completion is signalled through Done and WaitForToken, as well as any provided callback function
+func (w *GCPAuthWrapper) InitializeDevice(ctx context.Context, credentials *Token, callbackFunc TokenCallback) (*DeviceCode, error) {
+	if srv := w.takeOauthServer(); srv != nil {
+		srv.Shutdown(context.Background())
+	}
+	w.mutex.Lock()
+	w.internalHost = "" //Signing in again must go through InitializeDevice too
+	w.resetDoneLocked()
+	w.AuthError = nil
+	w.mutex.Unlock()
+
+	w.Config = w.newConfig(credentials, "")
+	if callbackFunc != nil {
+		w.CallbackFunc = callbackFunc
+	}
+
+	deviceAuth, err := w.Config.DeviceAuth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	w.mutex.Lock()
+	w.AuthURL = deviceAuth.VerificationURI
+	w.mutex.Unlock()
+
+	go func() {
+		oauthToken, err := w.Config.DeviceAccessToken(ctx, deviceAuth)
+		if err != nil {
+			w.fail(err)
+			return
+		}
+		w.setToken(oauthToken)
+	}()
+
+	return &DeviceCode{
+		UserCode:        deviceAuth.UserCode,
+		VerificationURL: deviceAuth.VerificationURI,
+		Expiry:          deviceAuth.Expiry,
+	}, nil
+}
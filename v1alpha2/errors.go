@@ -0,0 +1,132 @@
+package assistant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Errors that every error returned by this package can be classified as with errors.Is
+var (
+	ErrNotInitialized     = errors.New("assistant not initialized, must use NewAssistant")
+	ErrNotAuthenticated   = errors.New("not authenticated")
+	ErrQuotaExceeded      = errors.New("quota exceeded")
+	ErrStreamClosed       = errors.New("stream closed")
+	ErrInvalidAudioConfig = errors.New("invalid audio config")
+	ErrInvalidRequest     = errors.New("invalid request")
+	ErrEndOfUtterance     = errors.New("end of utterance, no more audio is accepted")
+	ErrDeadlineExceeded   = errors.New("deadline exceeded")
+	ErrClosed             = errors.New("assistant is closing")
+)
+
+// NotAuthenticatedError is returned when the user must sign in before talking to the Assistant, and matches ErrNotAuthenticated
+type NotAuthenticatedError struct {
+	AuthURL string //Where the user can sign in, if a sign in has been started
+	Err     error  //The error returned by Google, if any
+}
+
+// Error implements error
+func (e *NotAuthenticatedError) Error() string {
+	msg := "not authenticated"
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	if e.AuthURL != "" {
+		msg += ", must re-authenticate again: " + e.AuthURL
+	}
+	return msg
+}
+
+// Is matches ErrNotAuthenticated
+func (e *NotAuthenticatedError) Is(target error) bool {
+	return target == ErrNotAuthenticated
+}
+
+// Unwrap returns the error returned by Google, if any
+func (e *NotAuthenticatedError) Unwrap() error {
+	return e.Err
+}
+
+// RequestError holds an error returned while talking to the Assistant, classified by its gRPC status code
+type RequestError struct {
+	Op   string     //What was being done, such as "sending request"
+	Code codes.Code //gRPC status code of the error
+	Err  error      //The underlying error
+}
+
+// Error implements error
+func (e *RequestError) Error() string {
+	return fmt.Sprintf("error %s: %v", e.Op, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// Is matches the package error that the status code falls under, as well as context.Canceled and context.DeadlineExceeded
+func (e *RequestError) Is(target error) bool {
+	switch target {
+	case context.Canceled:
+		return e.Code == codes.Canceled
+	case context.DeadlineExceeded:
+		return e.Code == codes.DeadlineExceeded
+	case ErrNotAuthenticated:
+		return e.Code == codes.Unauthenticated || e.Code == codes.PermissionDenied
+	case ErrQuotaExceeded:
+		return e.Code == codes.ResourceExhausted
+	case ErrInvalidRequest:
+		return e.Code == codes.InvalidArgument || e.Code == codes.FailedPrecondition
+	case ErrDeadlineExceeded:
+		return e.Code == codes.DeadlineExceeded
+	case ErrStreamClosed:
+		return e.Code == codes.Canceled || e.Code == codes.Aborted || e.Code == codes.Unavailable
+	}
+	return false
+}
+
+// newRequestError classifies an error returned while talking to the Assistant, returning nil for a nil error
+func newRequestError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var requestErr *RequestError
+	var authErr *NotAuthenticatedError
+	if errors.As(err, &requestErr) || errors.As(err, &authErr) {
+		return err //Already classified
+	}
+	return &RequestError{Op: op, Code: errorCode(err), Err: err}
+}
+
+// errorCode returns the gRPC status code of an error
+func errorCode(err error) codes.Code {
+	switch {
+	case err == io.EOF, errors.Is(err, io.ErrUnexpectedEOF):
+		return codes.Aborted
+	case errors.Is(err, context.Canceled):
+		return codes.Canceled
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, ErrDeadlineExceeded):
+		return codes.DeadlineExceeded
+	case errors.Is(err, ErrNotAuthenticated):
+		return codes.Unauthenticated
+	}
+	if s, ok := status.FromError(err); ok {
+		return s.Code()
+	}
+	return codes.Unknown
+}
+
+// checkAuth returns a NotAuthenticatedError if the user must sign in before talking to the Assistant
+func (a *Assistant) checkAuth() error {
+	if a.GCPAuth == nil {
+		return ErrNotInitialized
+	}
+	if a.Insecure || a.GCPAuth.authenticated() {
+		return nil
+	}
+	return &NotAuthenticatedError{AuthURL: a.GCPAuth.authURL()}
+}
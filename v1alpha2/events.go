@@ -0,0 +1,185 @@
+package assistant
+
+import (
+	"context"
+	"io"
+
+	gassist "google.golang.org/genproto/googleapis/assistant/embedded/v1alpha2"
+)
+
+// EventType identifies what an Event carries
+type EventType int
+
+const (
+	EventTranscript     EventType = iota //Interim transcript of what the user has spoken so far
+	EventEndOfUtterance                  //The Assistant has stopped listening for audio
+	EventAudioOut                        //A chunk of the spoken answer
+	EventScreenOut                       //A visual answer
+	EventDeviceAction                    //A device action triggered by the query
+	EventDialogState                     //The dialog state for the next turn
+	EventDebugInfo                       //Debug info for the turn
+	EventReauth                          //Google rejected the current token, and the user must sign in again
+	EventTurnDone                        //The turn has finished, always the last event
+)
+
+// String returns the name of the event type
+func (t EventType) String() string {
+	switch t {
+	case EventTranscript:
+		return "transcript"
+	case EventEndOfUtterance:
+		return "end of utterance"
+	case EventAudioOut:
+		return "audio out"
+	case EventScreenOut:
+		return "screen out"
+	case EventDeviceAction:
+		return "device action"
+	case EventDialogState:
+		return "dialog state"
+	case EventDebugInfo:
+		return "debug info"
+	case EventReauth:
+		return "reauth"
+	case EventTurnDone:
+		return "turn done"
+	}
+	return "unknown"
+}
+
+// Event holds a single typed event from the Assistant's response stream, only the fields relevant to its type are set
+type Event struct {
+	Type EventType
+
+	Transcript    string                             //EventTranscript
+	Stability     float32                            //EventTranscript
+	SpeechResults []*gassist.SpeechRecognitionResult //EventTranscript
+
+	AudioOut       []byte                  //EventAudioOut
+	ScreenOut      *gassist.ScreenOut      //EventScreenOut
+	DeviceAction   string                  //EventDeviceAction
+	DialogStateOut *gassist.DialogStateOut //EventDialogState
+	DebugInfo      string                  //EventDebugInfo
+	AuthURL        string                  //EventReauth, where the user must sign in again
+
+	Err error //EventTurnDone, nil if the turn finished cleanly
+}
+
+// eventsFromResponse splits a single message from the response stream into typed events, in the order they should be handled
+func eventsFromResponse(response *gassist.AssistResponse) []Event {
+	events := make([]Event, 0)
+
+	if speechResults := response.GetSpeechResults(); len(speechResults) > 0 {
+		transcript := ""
+		for i := 0; i < len(speechResults); i++ {
+			if transcript == "" {
+				transcript = speechResults[i].Transcript
+			} else {
+				transcript += " " + speechResults[i].Transcript
+			}
+		}
+		events = append(events, Event{
+			Type:          EventTranscript,
+			Transcript:    transcript,
+			Stability:     speechResults[len(speechResults)-1].Stability,
+			SpeechResults: speechResults,
+		})
+	}
+	if response.GetEventType() == gassist.AssistResponse_END_OF_UTTERANCE {
+		events = append(events, Event{Type: EventEndOfUtterance})
+	}
+	if dialogStateOut := response.GetDialogStateOut(); dialogStateOut != nil {
+		events = append(events, Event{Type: EventDialogState, DialogStateOut: dialogStateOut})
+	}
+	if screenOut := response.GetScreenOut(); screenOut != nil {
+		events = append(events, Event{Type: EventScreenOut, ScreenOut: screenOut})
+	}
+	if deviceAction := response.GetDeviceAction(); deviceAction != nil && deviceAction.DeviceRequestJson != "" {
+		events = append(events, Event{Type: EventDeviceAction, DeviceAction: deviceAction.DeviceRequestJson})
+	}
+	if audioOut := response.GetAudioOut(); audioOut != nil && len(audioOut.AudioData) > 0 {
+		events = append(events, Event{Type: EventAudioOut, AudioOut: audioOut.AudioData})
+	}
+	if debugInfo := response.GetDebugInfo(); debugInfo != nil && debugInfo.AogAgentToAssistantJson != "" {
+		events = append(events, Event{Type: EventDebugInfo, DebugInfo: debugInfo.AogAgentToAssistantJson})
+	}
+
+	return events
+}
+
+// Events streams typed events from the current stream as they arrive, closing the channel after EventTurnDone
+// The request must already have been sent, and nothing else may read from the stream while events are being received
+// The channel should be drained to the end, but once the turn's context is done any events that aren't received are dropped so the stream can still finish
+func (c *Conversation) Events() <-chan Event {
+	events := make(chan Event, 16)
+	stream := c.AssistClient
+	ctx := c.streamContext()
+	emit := func(event Event) {
+		sendEvent(events, event, ctx.Done())
+	}
+	go func() {
+		defer close(events)
+		for {
+			response, err := stream.Recv()
+			if err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				err = newRequestError("getting response", err)
+				if authErr, ok := c.Assistant.reauthenticate(err); ok {
+					emit(Event{Type: EventReauth, AuthURL: authErr.AuthURL})
+					err = authErr
+				}
+				emit(Event{Type: EventTurnDone, Err: err})
+				return
+			}
+
+			for _, event := range eventsFromResponse(response) {
+				if event.Type == EventDialogState {
+					c.updateDialogState(event.DialogStateOut)
+				}
+				emit(event)
+			}
+		}
+	}()
+	return events
+}
+
+// sendEvent hands event over to events, dropping it only once done is closed and there's no room left, as nobody may be listening anymore and the stream is already being torn down
+func sendEvent(events chan<- Event, event Event, done <-chan struct{}) {
+	select {
+	case events <- event:
+		return
+	default:
+	}
+	select {
+	case events <- event:
+	case <-done:
+	}
+}
+
+// QueryEvents sends a text query and returns the Assistant's response as a stream of typed events
+func (r *TransportText) QueryEvents(textQuery string) (<-chan Event, error) {
+	return r.QueryEventsContext(r.Conversation.context(), textQuery)
+}
+
+// QueryEventsContext sends a text query and returns the Assistant's response as a stream of typed events, which ends early once ctx is done
+func (r *TransportText) QueryEventsContext(ctx context.Context, textQuery string) (<-chan Event, error) {
+	if err := r.Conversation.Assistant.checkAuth(); err != nil {
+		return nil, err
+	}
+	r.TextQuery = textQuery
+	ctx, endTurn := r.Conversation.startTurn(ctx)
+	err := r.Conversation.Assistant.retry(ctx, func() (bool, error) {
+		if err := r.Conversation.refresh(ctx, 0); err != nil {
+			return true, err
+		}
+		return true, r.send(textQuery)
+	})
+	if err != nil {
+		endTurn()
+		return nil, err
+	}
+	r.Conversation.keepTurn(endTurn)
+	return r.Conversation.Events(), nil
+}
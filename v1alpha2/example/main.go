@@ -2,86 +2,66 @@ package main
 
 import (
 	"bufio"
-	"encoding/json"
+	"context"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
 	"strings"
-	"time"
 
 	gassist "github.com/JoshuaDoes/google-assistant/v1alpha2"
-	"golang.org/x/oauth2"
 )
 
-var (
-	blob  *oauth2.Token
-	creds *gassist.Token
-)
-
-func cacheToken(token *oauth2.Token) {
-	blob = token
-	blobJSON, err := json.Marshal(blob)
-	if err != nil {
-		fmt.Println("! Failed to marshal token!")
-		return
-	}
-	if err := ioutil.WriteFile("token.blob", blobJSON, 0600); err != nil {
-		fmt.Println("! Failed to cache token!")
-	}
-}
+var creds *gassist.Token
 
 func main() {
-	var assistant gassist.Assistant
+	var assistant *gassist.Assistant
 	var err error
 
 	if len(os.Args) < 2 {
-		fmt.Println("! Usage: " + strings.Join(os.Args, " ") + " client_secret_XXXXXXXXXXXX-XXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXX.apps.googleusercontent.com.json")
+		fmt.Println("! Usage: " + strings.Join(os.Args, " ") + " client_secret_XXXXXXXXXXXX-XXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXX.apps.googleusercontent.com.json [-manual]")
 		os.Exit(1)
 	}
 
 	fmt.Println("> Loading credentials...")
-	credsJSON, err := ioutil.ReadFile(os.Args[1])
+	creds, err = gassist.GetCredentialsFromFile(os.Args[1])
 	if err != nil {
 		fmt.Println(">", err)
-	} else {
-		fmt.Println("> Parsing credentials...")
-		creds = &gassist.Token{}
-		if err := json.Unmarshal(credsJSON, &creds); err != nil {
-			fmt.Println(">", err)
-			os.Exit(1)
-		}
+		os.Exit(1)
 	}
 
-
-	fmt.Println("> Loading cached token...")
-	tokenJSON, err := ioutil.ReadFile("token.blob")
+	fmt.Println("> Initializing assistant...")
+	assistant, err = gassist.New(
+		gassist.WithCredentials(creds),
+		gassist.WithTokenStore(gassist.NewFileTokenStore("token.blob")),
+		gassist.WithDevice(gassist.NewDevice("254636TEST0001", "assistant-for-clinet")),
+	)
 	if err != nil {
-		fmt.Println(">", err)
-	} else {
-		fmt.Println("> Parsing cached token...")
-		blob = &oauth2.Token{}
-		if err := json.Unmarshal(tokenJSON, &blob); err != nil {
-			fmt.Println(">", err)
-			os.Exit(1)
-		}
+		fmt.Println(err)
+		os.Exit(1)
 	}
 
-	fmt.Println("> Initializing assistant...")
-	assistant, err = gassist.NewAssistant(creds, blob, cacheToken, ":25480", "en-US", gassist.NewDevice("254636TEST0001", "assistant-for-clinet"), gassist.NewAudioSettings(1, 1, 16000, 16000, 100))
+	authURL, err := assistant.AuthURL()
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
-
-	if assistant.GetAuthURL() != "" {
+	if authURL != "" {
 		fmt.Println(">")
-		fmt.Println("! Please log into Google:", assistant.GetAuthURL())
-		for blob == nil {
-			time.Sleep(time.Millisecond * 100)
+		if len(os.Args) > 2 && os.Args[2] == "-manual" {
+			err = assistant.GCPAuth.InitializeManual(creds, os.Stdout, os.Stdin, nil)
+		} else {
+			fmt.Println("! Please log into Google:", authURL)
+			err = assistant.GCPAuth.WaitForToken(context.Background())
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
 		}
 		fmt.Println(">")
 	}
+	assistant.GCPAuth.ReauthFunc = func(authURL string) {
+		fmt.Println("! Google signed you out, please log in again:", authURL)
+	}
 
 	fmt.Println("> Starting a new conversation...")
 	conversation, err := assistant.NewConversation(0)
@@ -2,24 +2,28 @@ package assistant
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"html"
+	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
 	"time"
 
 	"golang.org/x/oauth2"
 )
 
-//Token holds a Google OAuth2 token
-type Token struct {
-	Installed struct {
-		ClientID                string   `json:"client_id"`
-		ProjectID               string   `json:"project_id"`
-		AuthURI                 string   `json:"auth_uri"`
-		TokenURI                string   `json:"token_uri"`
-		AuthProviderX509CertURL string   `json:"auth_provider_x509_cert_url"`
-		ClientSecret            string   `json:"client_secret"`
-		RedirectUris            []string `json:"redirect_uris"`
-	} `json:"installed"`
+//GoogleEndpoint holds Google's OAuth2 endpoints
+var GoogleEndpoint = oauth2.Endpoint{
+	AuthURL:       "https://accounts.google.com/o/oauth2/auth",
+	TokenURL:      "https://accounts.google.com/o/oauth2/token",
+	DeviceAuthURL: "https://oauth2.googleapis.com/device/code",
 }
 
 //TokenCallback holds a callback function to return an OAuth2 token to, usually to cache for relogging in
@@ -33,69 +37,287 @@ type GCPAuthWrapper struct {
 	OauthSrv       *http.Server
 	OauthToken     *oauth2.Token
 	PermissionCode string
+	TokenStore     TokenStore       //Optional, receives every new token
+	Endpoint       *oauth2.Endpoint //Optional, overrides GoogleEndpoint
+	RevokeURL      string           //Optional, overrides GoogleRevokeURL
+	ReauthFunc     ReauthCallback   //Optional, told where to sign in again whenever Google rejects the current token
+	Logger         *log.Logger      //Optional, receives errors saving tokens to TokenStore
 
 	AuthError error
+
+	mutex        sync.Mutex
+	tokenSource  oauth2.TokenSource
+	custom       bool //Whether tokenSource was provided by the caller
+	state        string
+	verifier     string
+	done         chan struct{}
+	doneClosed   bool //Whether done has been closed, only touched with the mutex held
+	internalHost string                 //Address the loopback server listened on, to listen on again when signing in again
+	needsReauth  bool                   //Whether Google rejected the current token
+	relink       func() (string, error) //Starts signing in again for wrappers that don't sign in by themselves
+	reauthMutex  sync.Mutex
 }
 
 //Error returns an authentication error
 func (w *GCPAuthWrapper) Error() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
 	return w.AuthError
 }
 
 //Initialize initializes authentication to allow a user to sign in to the application
+//A loopback server is started on internalHost to receive the authorization code, which picks a free port when given a port of 0 or an empty host
 func (w *GCPAuthWrapper) Initialize(credentials *Token, internalHost string, callbackFunc TokenCallback) error {
-	w.AuthURL = credentials.Installed.RedirectUris[0]
+	if credentials == nil {
+		return errors.New("no credentials to sign in with")
+	}
+	redirectURL := credentials.redirectURL()
+
+	w.Config = w.newConfig(credentials, redirectURL)
+	if callbackFunc != nil {
+		w.CallbackFunc = callbackFunc
+	}
 
 	if w.PermissionCode != "" {
 		err := w.SetTokenSource(w.PermissionCode)
 		return err
 	}
 
-	w.Config = &oauth2.Config{
-		ClientID:     credentials.Installed.ClientID,
-		ClientSecret: credentials.Installed.ClientSecret,
-		Scopes: []string{
-			"https://www.googleapis.com/auth/assistant-sdk-prototype",
-		},
-		RedirectURL: w.AuthURL,
-		Endpoint: oauth2.Endpoint{
-			AuthURL:  "https://accounts.google.com/o/oauth2/auth",
-			TokenURL: "https://accounts.google.com/o/oauth2/token",
-		},
+	if internalHost == "" {
+		internalHost = "127.0.0.1:0"
 	}
-
-	w.AuthURL = w.Config.AuthCodeURL("state", oauth2.AccessTypeOffline)
-	if callbackFunc != nil {
-		w.CallbackFunc = callbackFunc
+	listener, err := net.Listen("tcp", internalHost)
+	if err != nil {
+		return err
 	}
+	w.Config.RedirectURL = loopbackRedirectURL(redirectURL, listener.Addr())
+	w.mutex.Lock()
+	w.internalHost = listener.Addr().String()
+	w.mutex.Unlock()
+
+	return w.serve(listener)
+}
 
-	w.OauthSrv = &http.Server{
-		Addr: internalHost,
-		Handler: http.DefaultServeMux,
+//serve starts a new sign in and serves the loopback server that receives its authorization code
+func (w *GCPAuthWrapper) serve(listener net.Listener) error {
+	if _, err := w.beginAuth(); err != nil {
+		listener.Close()
+		return err
 	}
 
-	http.HandleFunc("/", w.oauthHandler)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", w.oauthHandler)
+	srv := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: time.Second * 10,
+	}
+	w.mutex.Lock()
+	w.OauthSrv = srv
+	w.mutex.Unlock()
 	go func() {
-		if err := w.OauthSrv.ListenAndServe(); err != nil {
+		if err := srv.Serve(listener); err != nil && err != http.ErrServerClosed {
+			w.mutex.Lock()
 			w.AuthError = err
+			w.mutex.Unlock()
 		}
 	}()
-	time.Sleep(time.Second * 1) //Give it about 1 second to time out
 
-	return w.AuthError
+	return nil
+}
+
+//oauthServer returns the loopback server, if any
+func (w *GCPAuthWrapper) oauthServer() *http.Server {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.OauthSrv
+}
+
+//takeOauthServer returns the loopback server, if any, forgetting it so it's only stopped once
+func (w *GCPAuthWrapper) takeOauthServer() *http.Server {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	srv := w.OauthSrv
+	w.OauthSrv = nil
+	return srv
+}
+
+//beginAuth starts a new sign in with the current configuration, generating a fresh state and PKCE verifier for the auth URL
+func (w *GCPAuthWrapper) beginAuth() (string, error) {
+	state, err := randomState()
+	if err != nil {
+		return "", err
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	w.mutex.Lock()
+	w.state = state
+	w.verifier = verifier
+	w.resetDoneLocked()
+	w.AuthError = nil
+	w.AuthURL = w.Config.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.S256ChallengeOption(verifier))
+	w.mutex.Unlock()
+	return state, nil
+}
+
+//authURL returns the auth URL of the current sign in
+func (w *GCPAuthWrapper) authURL() string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.AuthURL
+}
+
+//newConfig returns the OAuth2 configuration for the given credentials, preferring the wrapper's endpoints over the ones in the credentials
+func (w *GCPAuthWrapper) newConfig(credentials *Token, redirectURL string) *oauth2.Config {
+	client := credentials.client()
+	endpoint := GoogleEndpoint
+	if client.AuthURI != "" {
+		endpoint.AuthURL = client.AuthURI
+	}
+	if client.TokenURI != "" {
+		endpoint.TokenURL = client.TokenURI
+	}
+	if w.Endpoint != nil {
+		endpoint = *w.Endpoint
+	}
+	return &oauth2.Config{
+		ClientID:     client.ClientID,
+		ClientSecret: client.ClientSecret,
+		Scopes: []string{
+			"https://www.googleapis.com/auth/assistant-sdk-prototype",
+		},
+		RedirectURL: redirectURL,
+		Endpoint:    endpoint,
+	}
+}
+
+//Done returns a channel that is closed once the user has finished signing in, or signing in has failed
+func (w *GCPAuthWrapper) Done() <-chan struct{} {
+	return w.doneChan()
+}
+
+func (w *GCPAuthWrapper) doneChan() chan struct{} {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.doneChanLocked()
+}
+
+func (w *GCPAuthWrapper) doneChanLocked() chan struct{} {
+	if w.done == nil {
+		w.done = make(chan struct{})
+		w.doneClosed = false
+	}
+	return w.done
+}
+
+//resetDoneLocked starts waiting for a new sign in, anyone still waiting on an unfinished one keeps waiting for the new one instead
+func (w *GCPAuthWrapper) resetDoneLocked() {
+	if w.doneClosed {
+		w.done = nil
+		w.doneClosed = false
+	}
+}
+
+//WaitForToken blocks until the user has finished signing in or the context is done
+func (w *GCPAuthWrapper) WaitForToken(ctx context.Context) error {
+	select {
+	case <-w.Done():
+		return w.Error()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+//fail ends signing in with an error
+func (w *GCPAuthWrapper) fail(err error) {
+	w.mutex.Lock()
+	w.AuthError = err
+	w.mutex.Unlock()
+	w.finish()
+}
+
+func (w *GCPAuthWrapper) finish() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	done := w.doneChanLocked()
+	if !w.doneClosed {
+		close(done)
+		w.doneClosed = true
+	}
 }
 
 func (w *GCPAuthWrapper) oauthHandler(writer http.ResponseWriter, req *http.Request) {
-	w.PermissionCode = req.URL.Query().Get("code")
-	if w.PermissionCode != "" {
-		if err := w.SetTokenSource(w.PermissionCode); err != nil {
-			writer.Write([]byte(fmt.Sprintf("<html><body><style>{background-color:black;color:white;}</style><h3>Authentication Failure</h3><p>The following error was provided: <strong>%v</strong>.</p><footer>You should try logging in again.</footer></body></html>")))
-		} else {
-			writer.Write([]byte(fmt.Sprintf("<html><body><style>body{background-color:black;color:white;}</style><h3>Authentication Successful</h3><p>Your token is <strong>%s</strong>.</p><footer>You may safely close this page.</footer></body></html>", w.PermissionCode)))
-		}
-	} else {
-		writer.Write([]byte(fmt.Sprintf("<html><body><style>body{background-color:black;color:white;}</style><h3>Authentication Failure</h3><p>No token received!</p><footer>You should try logging in again.</footer></body></html>")))
+	query := req.URL.Query()
+
+	w.mutex.Lock()
+	state := w.state
+	w.mutex.Unlock()
+	if state == "" || subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(state)) != 1 {
+		writeAuthPage(writer, http.StatusBadRequest, "Authentication Failure", "The sign-in request didn't come from this application.")
+		return
+	}
+	if reason := query.Get("error"); reason != "" {
+		writeAuthPage(writer, http.StatusForbidden, "Authentication Failure", fmt.Sprintf("The following error was provided: <strong>%s</strong>.", html.EscapeString(reason)))
+		return
 	}
+
+	permissionCode := query.Get("code")
+	if permissionCode == "" {
+		writeAuthPage(writer, http.StatusBadRequest, "Authentication Failure", "No token received!")
+		return
+	}
+	if err := w.SetTokenSource(permissionCode); err != nil {
+		writeAuthPage(writer, http.StatusInternalServerError, "Authentication Failure", fmt.Sprintf("The following error was provided: <strong>%s</strong>.", html.EscapeString(err.Error())))
+		return
+	}
+
+	w.mutex.Lock()
+	w.state = "" //The state may only be used once
+	srv := w.OauthSrv
+	w.mutex.Unlock()
+	writeAuthPage(writer, http.StatusOK, "Authentication Successful", "You may safely close this page.")
+
+	if srv != nil {
+		go srv.Shutdown(context.Background())
+	}
+}
+
+func writeAuthPage(writer http.ResponseWriter, statusCode int, title, message string) {
+	footer := "You should try logging in again."
+	if statusCode == http.StatusOK {
+		footer = ""
+	}
+	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writer.WriteHeader(statusCode)
+	writer.Write([]byte(fmt.Sprintf("<html><body><style>body{background-color:black;color:white;}</style><h3>%s</h3><p>%s</p><footer>%s</footer></body></html>", title, message, footer)))
+}
+
+//randomState returns an unguessable state to tie an authorization response to the request that started it
+func randomState() (string, error) {
+	state := make([]byte, 32)
+	if _, err := rand.Read(state); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(state), nil
+}
+
+//loopbackRedirectURL points a loopback redirect URL without a port at the port that is actually being listened on
+func loopbackRedirectURL(redirectURL string, addr net.Addr) string {
+	parsed, err := url.Parse(redirectURL)
+	if err != nil || parsed.Port() != "" {
+		return redirectURL
+	}
+	switch parsed.Hostname() {
+	case "localhost", "127.0.0.1", "::1":
+	default:
+		return redirectURL
+	}
+
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return redirectURL
+	}
+	parsed.Host = net.JoinHostPort(parsed.Hostname(), strconv.Itoa(tcpAddr.Port))
+	return parsed.String()
 }
 
 //SetTokenSource is used to finish the authentication process, as well as calling any provided callback function
@@ -104,14 +326,140 @@ func (w *GCPAuthWrapper) SetTokenSource(permissionCode string) error {
 
 	ctx := context.Background()
 
-	w.OauthToken, err = w.Config.Exchange(ctx, permissionCode)
+	w.mutex.Lock()
+	verifier := w.verifier
+	w.mutex.Unlock()
+
+	opts := make([]oauth2.AuthCodeOption, 0)
+	if verifier != "" {
+		opts = append(opts, oauth2.VerifierOption(verifier))
+	}
+	oauthToken, err := w.Config.Exchange(ctx, permissionCode, opts...)
 	if err != nil {
 		return err
 	}
 
+	w.setToken(oauthToken)
+	return nil
+}
+
+//setToken finishes signing in with a newly acquired token
+func (w *GCPAuthWrapper) setToken(oauthToken *oauth2.Token) {
+	w.mutex.Lock()
+	w.OauthToken = oauthToken
+	w.tokenSource = nil //Start refreshing from the new token
+	w.custom = false
+	w.needsReauth = false
+	w.AuthError = nil
+	w.mutex.Unlock()
+
 	if w.CallbackFunc != nil {
-		go w.CallbackFunc(w.OauthToken)
+		go w.CallbackFunc(oauthToken)
 	}
 
-	return nil
+	if w.TokenStore != nil {
+		if err := w.TokenStore.Save(oauthToken); err != nil {
+			w.logf("assistant: error saving token, the user must sign in again after a restart: %v", err) //Signing in still succeeded
+		}
+	}
+
+	w.finish()
+}
+
+// Token returns the current OAuth2 token, which may have been refreshed since signing in
+func (w *GCPAuthWrapper) Token() *oauth2.Token {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.OauthToken
+}
+
+// UseTokenSource authenticates with tokens from a caller-provided source instead of signing in, such as one from golang.org/x/oauth2/google
+func (w *GCPAuthWrapper) UseTokenSource(tokenSource oauth2.TokenSource) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.tokenSource = oauth2.ReuseTokenSource(nil, tokenSource)
+	w.custom = true
+}
+
+// authenticated returns true if there's a usable token, or a way to get one without the user signing in again
+func (w *GCPAuthWrapper) authenticated() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.custom {
+		return true
+	}
+	if w.OauthToken == nil {
+		return false
+	}
+	return w.OauthToken.Valid() || (w.OauthToken.RefreshToken != "" && w.Config != nil)
+}
+
+// TokenSource returns a token source that refreshes the current OAuth2 token as needed, shared by every connection so a token is only refreshed once
+// Refreshed tokens are written back to OauthToken and passed on to the callback function and token store, and signing out stops it from handing out tokens
+func (w *GCPAuthWrapper) TokenSource() oauth2.TokenSource {
+	return &notifyingTokenSource{wrapper: w}
+}
+
+// source returns the token source for the current token, creating it if the token changed
+func (w *GCPAuthWrapper) source() (oauth2.TokenSource, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.tokenSource == nil {
+		if w.OauthToken == nil {
+			return nil, ErrNotAuthenticated
+		}
+		if w.Config != nil {
+			w.tokenSource = w.Config.TokenSource(context.Background(), w.OauthToken)
+		} else {
+			w.tokenSource = oauth2.StaticTokenSource(w.OauthToken) //Nothing to refresh with
+		}
+	}
+	return w.tokenSource, nil
+}
+
+// updateToken stores a refreshed token and notifies the caller, if it's actually new and came from the current source
+func (w *GCPAuthWrapper) updateToken(source oauth2.TokenSource, oauthToken *oauth2.Token) {
+	w.mutex.Lock()
+	if w.tokenSource != source || (w.OauthToken != nil && w.OauthToken.AccessToken == oauthToken.AccessToken) {
+		w.mutex.Unlock()
+		return
+	}
+	w.OauthToken = oauthToken
+	w.mutex.Unlock()
+
+	if w.CallbackFunc != nil {
+		go w.CallbackFunc(oauthToken)
+	}
+	if w.TokenStore != nil {
+		if err := w.TokenStore.Save(oauthToken); err != nil {
+			w.logf("assistant: error saving refreshed token, the stored token will be stale after a restart: %v", err)
+		}
+	}
+}
+
+// logf logs a message if the wrapper has a logger
+func (w *GCPAuthWrapper) logf(format string, v ...interface{}) {
+	if w.Logger != nil {
+		w.Logger.Printf(format, v...)
+	}
+}
+
+// notifyingTokenSource hands out tokens from the wrapper's current source and reports every one of them back to the wrapper
+type notifyingTokenSource struct {
+	wrapper *GCPAuthWrapper
+}
+
+// Token implements oauth2.TokenSource
+func (s *notifyingTokenSource) Token() (*oauth2.Token, error) {
+	source, err := s.wrapper.source()
+	if err != nil {
+		return nil, err
+	}
+	oauthToken, err := source.Token()
+	if err != nil {
+		return nil, err
+	}
+	s.wrapper.updateToken(source, oauthToken)
+	return oauthToken, nil
 }
@@ -0,0 +1,98 @@
+package assistant
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// GoogleRevokeURL is Google's OAuth2 token revocation endpoint
+const GoogleRevokeURL = "https://oauth2.googleapis.com/revoke"
+
+// Logout signs the account out of the Assistant, see GCPAuthWrapper.Logout
+func (a *Assistant) Logout(ctx context.Context) error {
+	if a.GCPAuth == nil {
+		return nil
+	}
+	return a.GCPAuth.Logout(ctx)
+}
+
+// Logout revokes the current token, forgets it and deletes it from the token store, after which queries fail with ErrNotAuthenticated until the user signs in again
+// The token is forgotten even if revoking it fails, in which case the error is returned
+func (w *GCPAuthWrapper) Logout(ctx context.Context) error {
+	if srv := w.takeOauthServer(); srv != nil {
+		srv.Shutdown(context.Background())
+	}
+
+	w.mutex.Lock()
+	oauthToken := w.OauthToken
+	w.OauthToken = nil
+	w.tokenSource = nil
+	w.custom = false
+	w.state = ""
+	w.verifier = ""
+	w.resetDoneLocked()
+	w.AuthError = nil
+	w.internalHost = ""
+	w.needsReauth = false
+	w.AuthURL = ""
+	w.mutex.Unlock()
+	w.PermissionCode = ""
+
+	var err error
+	if oauthToken != nil {
+		err = w.revoke(ctx, oauthToken)
+	}
+	if w.TokenStore != nil {
+		if deleteErr := w.TokenStore.Delete(); deleteErr != nil && err == nil {
+			err = fmt.Errorf("error deleting token: %v", deleteErr)
+		}
+	}
+	return err
+}
+
+// revoke revokes the refresh token, which also revokes every access token issued from it, or the access token if there isn't one
+func (w *GCPAuthWrapper) revoke(ctx context.Context, oauthToken *oauth2.Token) error {
+	token := oauthToken.RefreshToken
+	if token == "" {
+		token = oauthToken.AccessToken
+	}
+	if token == "" {
+		return nil
+	}
+
+	revokeURL := w.RevokeURL
+	if revokeURL == "" {
+		revokeURL = GoogleRevokeURL
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", revokeURL, strings.NewReader(url.Values{"token": {token}}.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	client := http.DefaultClient
+	if ctxClient, ok := ctx.Value(oauth2.HTTPClient).(*http.Client); ok {
+		client = ctxClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error revoking token: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 1<<10))
+		if res.StatusCode == http.StatusBadRequest && strings.Contains(string(body), "invalid_token") {
+			return nil //Already revoked or expired
+		}
+		return fmt.Errorf("error revoking token: %s: %s", res.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
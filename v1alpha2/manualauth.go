@@ -0,0 +1,79 @@
+package assistant
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// InitializeManual signs in from a terminal that can't be reached by a local HTTP listener, replacing any loopback server started by Initialize
+// The auth URL is printed to out, and the user pastes back either the code or the whole address their browser was redirected to, which is read from in
+// It blocks until the pasted code has been exchanged for a token through SetTokenSource
+func (w *GCPAuthWrapper) InitializeManual(credentials *Token, out io.Writer, in io.Reader, callbackFunc TokenCallback) error {
+	if srv := w.takeOauthServer(); srv != nil {
+		srv.Shutdown(context.Background())
+	}
+	w.mutex.Lock()
+	w.internalHost = "" //Signing in again must be completed manually too
+	w.mutex.Unlock()
+
+	w.Config = w.newConfig(credentials, credentials.redirectURL())
+	if callbackFunc != nil {
+		w.CallbackFunc = callbackFunc
+	}
+
+	if _, err := w.beginAuth(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Please log into Google: %s\n", w.authURL())
+	fmt.Fprintf(out, "Once you've signed in, paste the code or the address you were sent to: ")
+
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return fmt.Errorf("error reading code: %v", err)
+	}
+
+	permissionCode, err := w.parsePastedCode(strings.TrimSpace(line))
+	if err != nil {
+		return err
+	}
+	return w.SetTokenSource(permissionCode)
+}
+
+// parsePastedCode returns the authorization code from a pasted code or redirect address, checking the state of the latter
+func (w *GCPAuthWrapper) parsePastedCode(pasted string) (string, error) {
+	if pasted == "" {
+		return "", errors.New("no code was pasted")
+	}
+	if !strings.Contains(pasted, "?") {
+		return pasted, nil
+	}
+
+	redirect, err := url.Parse(pasted)
+	if err != nil {
+		return "", fmt.Errorf("error parsing pasted address: %v", err)
+	}
+	query := redirect.Query()
+	if reason := query.Get("error"); reason != "" {
+		return "", fmt.Errorf("sign in failed: %s", reason)
+	}
+
+	w.mutex.Lock()
+	state := w.state
+	w.mutex.Unlock()
+	if subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(state)) != 1 {
+		return "", errors.New("pasted address didn't come from this sign in request")
+	}
+
+	permissionCode := query.Get("code")
+	if permissionCode == "" {
+		return "", errors.New("pasted address has no code")
+	}
+	return permissionCode, nil
+}
@@ -0,0 +1,278 @@
+package assistant
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/text/language"
+	"google.golang.org/api/option"
+	gassist "google.golang.org/genproto/googleapis/assistant/embedded/v1alpha2"
+	"google.golang.org/grpc"
+)
+
+// DefaultLanguageCode is the language used by New without WithLanguage
+const DefaultLanguageCode = "en-US"
+
+// DefaultInternalHost is where New listens for the sign in redirect without WithListener, picking a free loopback port
+const DefaultInternalHost = "127.0.0.1:0"
+
+// DefaultTimeouts is used by New without WithTimeouts, leaving time to first response unlimited so a voice query can wait for the user to start speaking
+var DefaultTimeouts = Timeouts{
+	Dial: time.Second * 20,
+	Idle: time.Second * 30,
+	Turn: time.Minute * 3,
+}
+
+// DefaultAudioSettings returns the audio settings used by New without WithAudioSettings, 16kHz signed 16-bit little-endian linear PCM in both directions at full volume
+func DefaultAudioSettings() *AudioSettings {
+	return NewAudioSettings(gassist.AudioInConfig_LINEAR16, gassist.AudioOutConfig_LINEAR16, 16000, 16000, 100)
+}
+
+// Option configures an Assistant created with New
+type Option func(*options) error
+
+// options holds everything New needs to create an Assistant
+type options struct {
+	credentials   *Token
+	oauthToken    *oauth2.Token
+	tokenStore    TokenStore
+	tokenSource   oauth2.TokenSource
+	callbackFunc  TokenCallback
+	internalHost  string
+	languageCode  string
+	device        *Device
+	audioSettings *AudioSettings
+	endpoint      string
+	insecure      bool
+	dialOptions   []grpc.DialOption
+	clientOptions []option.ClientOption
+	logger        *log.Logger
+	retryPolicy   RetryPolicy
+	timeouts      Timeouts
+}
+
+// WithCredentials signs in with the given OAuth2 client or authorized user credentials
+func WithCredentials(credentials *Token) Option {
+	return func(o *options) error {
+		o.credentials = credentials
+		return nil
+	}
+}
+
+// WithCredentialsFile signs in with the credentials in a JSON file downloaded from the Google Cloud console, or created by gcloud
+func WithCredentialsFile(path string) Option {
+	return func(o *options) error {
+		credentials, err := GetCredentialsFromFile(path)
+		if err != nil {
+			return fmt.Errorf("error loading credentials: %v", err)
+		}
+		o.credentials = credentials
+		return nil
+	}
+}
+
+// WithToken starts from an existing OAuth2 token, such as one saved by a TokenCallback, instead of asking the user to sign in
+func WithToken(oauthToken *oauth2.Token) Option {
+	return func(o *options) error {
+		o.oauthToken = oauthToken
+		return nil
+	}
+}
+
+// WithTokenStore loads the OAuth2 token from tokenStore and saves every new token back to it, taking priority over WithToken once a token has been stored
+func WithTokenStore(tokenStore TokenStore) Option {
+	return func(o *options) error {
+		o.tokenStore = tokenStore
+		return nil
+	}
+}
+
+// WithTokenSource authenticates every request with tokens from tokenSource instead of signing in
+func WithTokenSource(tokenSource oauth2.TokenSource) Option {
+	return func(o *options) error {
+		o.tokenSource = tokenSource
+		return nil
+	}
+}
+
+// WithTokenCallback passes every new OAuth2 token to callbackFunc
+func WithTokenCallback(callbackFunc TokenCallback) Option {
+	return func(o *options) error {
+		o.callbackFunc = callbackFunc
+		return nil
+	}
+}
+
+// WithListener serves the sign in redirect on internalHost, which must match a redirect URI of the credentials if they don't use a loopback address
+func WithListener(internalHost string) Option {
+	return func(o *options) error {
+		o.internalHost = internalHost
+		return nil
+	}
+}
+
+// WithLanguage sets the default language of every conversation, as a BCP-47 code such as "en-US"
+func WithLanguage(languageCode string) Option {
+	return func(o *options) error {
+		o.languageCode = languageCode
+		return nil
+	}
+}
+
+// WithDevice sets the registered device to talk to the Assistant as, see NewDevice
+func WithDevice(device *Device) Option {
+	return func(o *options) error {
+		o.device = device
+		return nil
+	}
+}
+
+// WithAudioSettings sets the audio formats and the default volume of every conversation
+func WithAudioSettings(audioSettings *AudioSettings) Option {
+	return func(o *options) error {
+		o.audioSettings = audioSettings
+		return nil
+	}
+}
+
+// WithEndpoint talks to the Assistant API at endpoint instead of DefaultEndpoint, see Assistant.SetEndpoint
+func WithEndpoint(endpoint string, insecure bool, dialOptions ...grpc.DialOption) Option {
+	return func(o *options) error {
+		o.endpoint = endpoint
+		o.insecure = insecure
+		o.dialOptions = append(o.dialOptions, dialOptions...)
+		return nil
+	}
+}
+
+// WithClientOptions adds extra Google API client options used when dialling the Assistant API
+func WithClientOptions(clientOptions ...option.ClientOption) Option {
+	return func(o *options) error {
+		o.clientOptions = append(o.clientOptions, clientOptions...)
+		return nil
+	}
+}
+
+// WithLogger logs retries, re-authentication and other notable events to logger
+func WithLogger(logger *log.Logger) Option {
+	return func(o *options) error {
+		o.logger = logger
+		return nil
+	}
+}
+
+// WithRetryPolicy sets how failed queries are retried, see NoRetryPolicy to disable retrying
+func WithRetryPolicy(retryPolicy RetryPolicy) Option {
+	return func(o *options) error {
+		o.retryPolicy = retryPolicy
+		return nil
+	}
+}
+
+// WithTimeouts sets how long each part of talking to the Assistant may take
+func WithTimeouts(timeouts Timeouts) Option {
+	return func(o *options) error {
+		o.timeouts = timeouts
+		return nil
+	}
+}
+
+// New returns a new Google Assistant to operate on, validating every option before anything is dialled or signed in to
+// A device and a way to authenticate, such as WithCredentials or WithTokenSource, are required, and everything else has a default
+// If the user must sign in, GCPAuth.AuthURL is set and the Assistant is ready once GCPAuth.WaitForToken returns
+func New(opts ...Option) (*Assistant, error) {
+	o := &options{
+		internalHost:  DefaultInternalHost,
+		languageCode:  DefaultLanguageCode,
+		audioSettings: DefaultAudioSettings(),
+		retryPolicy:   DefaultRetryPolicy,
+		timeouts:      DefaultTimeouts,
+	}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+
+	oauthToken := o.oauthToken
+	if o.tokenStore != nil {
+		storedToken, err := o.tokenStore.Load()
+		if err != nil {
+			return nil, fmt.Errorf("error loading token: %v", err)
+		}
+		if storedToken != nil {
+			oauthToken = storedToken
+		}
+	}
+	if oauthToken == nil {
+		oauthToken = o.credentials.OauthToken()
+	}
+
+	assistant := newAssistant(oauthToken, o.languageCode, o.device, o.audioSettings)
+	assistant.Endpoint = o.endpoint
+	assistant.Insecure = o.insecure
+	assistant.DialOptions = o.dialOptions
+	assistant.ClientOptions = o.clientOptions
+	assistant.Logger = o.logger
+	assistant.GCPAuth.Logger = o.logger
+	retryPolicy := o.retryPolicy
+	assistant.RetryPolicy = &retryPolicy
+	assistant.Timeouts = o.timeouts
+	assistant.GCPAuth.TokenStore = o.tokenStore
+
+	switch {
+	case o.tokenSource != nil:
+		assistant.GCPAuth.UseTokenSource(o.tokenSource)
+		if o.callbackFunc != nil {
+			assistant.GCPAuth.CallbackFunc = o.callbackFunc
+		}
+	case o.credentials != nil || oauthToken != nil:
+		if err := assistant.authenticate(o.credentials, o.internalHost, o.callbackFunc); err != nil {
+			return nil, err
+		}
+	}
+	return &assistant, nil
+}
+
+// validate returns an error describing the first invalid option
+func (o *options) validate() error {
+	if o.device == nil || o.device.DeviceConfig == nil || o.device.DeviceId == "" || o.device.DeviceModelId == "" {
+		return errors.New("a device with both a device ID and a device model ID is required, see WithDevice")
+	}
+	if _, err := language.Parse(o.languageCode); err != nil {
+		return fmt.Errorf("invalid language code %q: %v", o.languageCode, err)
+	}
+	if err := o.audioSettings.Validate(); err != nil {
+		return err
+	}
+
+	if o.tokenSource != nil && o.credentials != nil {
+		return errors.New("WithTokenSource can't be combined with WithCredentials")
+	}
+	if o.tokenSource == nil && o.credentials == nil && o.oauthToken == nil && !o.insecure {
+		return errors.New("no way to authenticate, see WithCredentials, WithToken or WithTokenSource")
+	}
+	if o.tokenStore != nil && o.credentials == nil {
+		return errors.New("WithTokenStore needs WithCredentials to refresh the stored token")
+	}
+
+	policy := o.retryPolicy
+	if policy.InitialBackoff < 0 || policy.MaxBackoff < 0 {
+		return errors.New("retry policy backoffs can't be negative")
+	}
+	if policy.Jitter < 0 || policy.Jitter > 1 {
+		return fmt.Errorf("retry policy jitter must be from 0.0 to 1.0, got %v", policy.Jitter)
+	}
+
+	timeouts := o.timeouts
+	if timeouts.Dial < 0 || timeouts.FirstResponse < 0 || timeouts.Idle < 0 || timeouts.Turn < 0 {
+		return errors.New("timeouts can't be negative")
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package assistant
+
+import (
+	"errors"
+	"net"
+)
+
+// ReauthCallback holds a callback function to tell where the user must sign in again after Google rejected the current token
+type ReauthCallback func(authURL string)
+
+// NeedsReauth returns true if Google rejected the current token and the user hasn't signed in again yet
+func (w *GCPAuthWrapper) NeedsReauth() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.needsReauth
+}
+
+// Reauthenticate forgets a token that Google has rejected, such as a revoked or expired refresh token, and starts signing in again, returning the fresh auth URL
+// Signing in again is completed the same way as the first time: through the loopback server if Initialize was used, and through SetTokenSource, InitializeManual or InitializeDevice otherwise
+// Done is closed again once the user has signed in, and calling Reauthenticate again before then returns the same auth URL
+func (w *GCPAuthWrapper) Reauthenticate() (string, error) {
+	w.reauthMutex.Lock()
+	defer w.reauthMutex.Unlock()
+
+	w.mutex.Lock()
+	if w.needsReauth {
+		w.mutex.Unlock()
+		return w.AuthURL, nil
+	}
+	if w.custom {
+		w.mutex.Unlock()
+		return "", errors.New("can't sign in again with a caller-provided token source")
+	}
+	if w.Config == nil {
+		w.mutex.Unlock()
+		return "", ErrNotInitialized
+	}
+	w.needsReauth = true
+	w.OauthToken = nil
+	w.tokenSource = nil
+	w.resetDoneLocked()
+	w.AuthError = nil
+	internalHost := w.internalHost
+	relink := w.relink
+	w.mutex.Unlock()
+
+	var authURL string
+	var err error
+	switch {
+	case relink != nil:
+		authURL, err = relink()
+	case internalHost != "":
+		if err = w.listen(internalHost); err == nil {
+			authURL = w.authURL()
+		}
+	default:
+		if _, err = w.beginAuth(); err == nil {
+			authURL = w.authURL()
+		}
+	}
+	if err != nil {
+		w.mutex.Lock()
+		w.needsReauth = false //Let the next rejection try again
+		w.mutex.Unlock()
+		return "", err
+	}
+
+	if w.ReauthFunc != nil {
+		go w.ReauthFunc(authURL)
+	}
+	return authURL, nil
+}
+
+// listen serves the loopback server on the address it listened on before, so the redirect URL stays the same
+func (w *GCPAuthWrapper) listen(internalHost string) error {
+	if srv := w.takeOauthServer(); srv != nil {
+		srv.Close()
+	}
+	listener, err := net.Listen("tcp", internalHost)
+	if err != nil {
+		return err
+	}
+	return w.serve(listener)
+}
+
+// reauthenticate moves the Assistant into the needs-reauth state if err means Google rejected its token, returning an error holding the fresh auth URL
+func (a *Assistant) reauthenticate(err error) (*NotAuthenticatedError, bool) {
+	var requestErr *RequestError
+	if a.Insecure || a.GCPAuth == nil || !errors.As(err, &requestErr) || !errors.Is(requestErr, ErrNotAuthenticated) {
+		return nil, false
+	}
+	authURL, reauthErr := a.GCPAuth.Reauthenticate()
+	if reauthErr != nil {
+		a.logf("assistant: Google rejected the token, but signing in again failed: %v", reauthErr)
+		return nil, false
+	}
+	a.logf("assistant: Google rejected the token, the user must sign in again: %v", err)
+	return &NotAuthenticatedError{AuthURL: authURL, Err: err}, true
+}
@@ -0,0 +1,62 @@
+package assistant
+
+import (
+	gassist "google.golang.org/genproto/googleapis/assistant/embedded/v1alpha2"
+)
+
+// Response holds everything the Assistant returned for a single turn of a conversation
+type Response struct {
+	Text              string                                //Supplemental display text, if any
+	AudioOut          []byte                                //Spoken answer in the configured AudioOutEncoding
+	ScreenOut         *gassist.ScreenOut                    //Visual answer, only returned when a screen mode is requested
+	DeviceActions     []string                              //JSON device requests triggered by the query
+	MicrophoneMode    gassist.DialogStateOut_MicrophoneMode //Whether the Assistant expects a follow-on query
+	VolumePercentage  int32                                 //Updated volume level, or 0 if unchanged
+	ConversationState []byte                                //Opaque state to pass along with the next turn
+	SpeechResults     []*gassist.SpeechRecognitionResult    //Latest speech recognition results for audio queries
+	EndOfUtterance    bool                                  //Whether the Assistant detected the end of the user's query
+	DebugInfo         []string                              //Actions on Google agent JSON, only returned when debugging is requested
+}
+
+// FollowOn returns true if the Assistant expects the user to respond immediately
+func (r *Response) FollowOn() bool {
+	return r.MicrophoneMode == gassist.DialogStateOut_DIALOG_FOLLOW_ON
+}
+
+// ScreenOutHTML returns the HTML visual answer, or an empty string if there isn't one
+func (r *Response) ScreenOutHTML() string {
+	if r.ScreenOut == nil || r.ScreenOut.Format != gassist.ScreenOut_HTML {
+		return ""
+	}
+	return string(r.ScreenOut.Data)
+}
+
+// add merges a single message from the response stream into the response
+func (r *Response) add(response *gassist.AssistResponse) {
+	if response.GetEventType() == gassist.AssistResponse_END_OF_UTTERANCE {
+		r.EndOfUtterance = true
+	}
+	if speechResults := response.GetSpeechResults(); len(speechResults) > 0 {
+		r.SpeechResults = speechResults
+	}
+	if audioOut := response.GetAudioOut(); audioOut != nil {
+		r.AudioOut = append(r.AudioOut, audioOut.AudioData...)
+	}
+	if screenOut := response.GetScreenOut(); screenOut != nil {
+		r.ScreenOut = screenOut
+	}
+	if deviceAction := response.GetDeviceAction(); deviceAction != nil && deviceAction.DeviceRequestJson != "" {
+		r.DeviceActions = append(r.DeviceActions, deviceAction.DeviceRequestJson)
+	}
+	if dialogStateOut := response.GetDialogStateOut(); dialogStateOut != nil {
+		if dialogStateOut.SupplementalDisplayText != "" {
+			r.Text = dialogStateOut.SupplementalDisplayText
+		}
+		r.MicrophoneMode = dialogStateOut.MicrophoneMode
+		r.VolumePercentage = dialogStateOut.VolumePercentage
+		r.ConversationState = dialogStateOut.ConversationState
+	}
+	if debugInfo := response.GetDebugInfo(); debugInfo != nil && debugInfo.AogAgentToAssistantJson != "" {
+		r.DebugInfo = append(r.DebugInfo, debugInfo.AogAgentToAssistantJson)
+	}
+}
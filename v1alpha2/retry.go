@@ -0,0 +1,132 @@
+package assistant
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+// RetryPolicy decides whether a failed query is sent again on a new stream, and how long to wait before doing so
+// A query is never sent again once the Assistant has answered it with a dialog state, so a turn can't run twice
+type RetryPolicy struct {
+	MaxAttempts    int           //Total attempts including the first one, 1 or less disables retrying
+	InitialBackoff time.Duration //How long to wait before the first retry
+	MaxBackoff     time.Duration //Upper limit of the wait between retries, 0 for no limit
+	Multiplier     float64       //How much the wait grows after every retry, values below 1 are treated as 1
+	Jitter         float64       //Fraction of every wait to randomize, from 0.0 (none) to 1.0 (anywhere between 0 and double)
+	RetryableCodes []codes.Code  //gRPC status codes that are worth retrying
+}
+
+// DefaultRetryPolicy is used by an Assistant without a RetryPolicy of its own
+// codes.Aborted covers the Assistant closing the stream before answering, which used to be worked around by re-sending the query once
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: time.Millisecond * 250,
+	MaxBackoff:     time.Second * 5,
+	Multiplier:     2,
+	Jitter:         0.2,
+	RetryableCodes: []codes.Code{codes.Unavailable, codes.DeadlineExceeded, codes.Internal, codes.Aborted},
+}
+
+// NoRetryPolicy disables retrying failed queries
+var NoRetryPolicy = RetryPolicy{MaxAttempts: 1}
+
+// Retryable returns true if err was caused by one of the retryable status codes
+// A turn that ran out of Timeouts.Turn is never retried, as the time it was allowed has already been spent
+func (p *RetryPolicy) Retryable(err error) bool {
+	if err == nil || errors.Is(err, ErrTurnTimeout) {
+		return false
+	}
+	code := errorCode(err)
+	var requestErr *RequestError
+	if errors.As(err, &requestErr) {
+		code = requestErr.Code
+	}
+	for _, retryableCode := range p.RetryableCodes {
+		if code == retryableCode {
+			return true
+		}
+	}
+	return false
+}
+
+// Backoff returns how long to wait after the given failed attempt, counting from 1
+func (p *RetryPolicy) Backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	backoff := float64(p.InitialBackoff)
+	for i := 1; i < attempt; i++ {
+		backoff *= multiplier
+		if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+		backoff = float64(p.MaxBackoff)
+	}
+
+	if p.Jitter > 0 {
+		jitter := p.Jitter
+		if jitter > 1 {
+			jitter = 1
+		}
+		backoff += backoff * jitter * (rand.Float64()*2 - 1)
+	}
+	return time.Duration(backoff)
+}
+
+// retryPolicy returns the retry policy of the Assistant, falling back to DefaultRetryPolicy
+func (a *Assistant) retryPolicy() *RetryPolicy {
+	if a.RetryPolicy != nil {
+		return a.RetryPolicy
+	}
+	return &DefaultRetryPolicy
+}
+
+// retry calls attempt until it succeeds, fails for good, or runs out of attempts
+// attempt returns whether it's safe to call it again, which is no longer the case once the Assistant has answered
+// If Google rejects the token and the user is told where to sign in again through GCPAuthWrapper.ReauthFunc, the attempt is resumed once they have
+func (a *Assistant) retry(ctx context.Context, attempt func() (retryable bool, err error)) error {
+	policy := a.retryPolicy()
+	resumed := false
+	for i := 1; ; i++ {
+		retryable, err := attempt()
+		if err == nil {
+			return nil
+		}
+		if authErr, ok := a.reauthenticate(err); ok {
+			if !retryable || resumed || a.GCPAuth.ReauthFunc == nil {
+				return authErr
+			}
+			resumed = true
+			select {
+			case <-a.GCPAuth.Done():
+				if err := a.GCPAuth.Error(); err != nil {
+					return err
+				}
+				continue
+			case <-ctx.Done():
+				return authErr
+			}
+		}
+		if !retryable || i >= policy.MaxAttempts || !policy.Retryable(err) {
+			return err
+		}
+
+		backoff := policy.Backoff(i)
+		a.logf("assistant: retrying in %v after attempt %d failed: %v", backoff, i, err)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+}
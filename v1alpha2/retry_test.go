@@ -0,0 +1,104 @@
+package assistant_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	assistant "github.com/JoshuaDoes/google-assistant/v1alpha2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy assistant.RetryPolicy
+		want   []time.Duration
+	}{
+		{
+			name:   "grows up to the cap",
+			policy: assistant.RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2},
+			want:   []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second},
+		},
+		{
+			name:   "no cap",
+			policy: assistant.RetryPolicy{InitialBackoff: 100 * time.Millisecond, Multiplier: 3},
+			want:   []time.Duration{100 * time.Millisecond, 300 * time.Millisecond, 900 * time.Millisecond, 2700 * time.Millisecond},
+		},
+		{
+			name:   "multiplier below 1",
+			policy: assistant.RetryPolicy{InitialBackoff: 100 * time.Millisecond, Multiplier: 0.5},
+			want:   []time.Duration{100 * time.Millisecond, 100 * time.Millisecond, 100 * time.Millisecond},
+		},
+		{
+			name:   "initial backoff above the cap",
+			policy: assistant.RetryPolicy{InitialBackoff: 2 * time.Second, MaxBackoff: time.Second, Multiplier: 2},
+			want:   []time.Duration{time.Second, time.Second},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for i, want := range test.want {
+				if got := test.policy.Backoff(i + 1); got != want {
+					t.Errorf("attempt %d got %v, want %v", i+1, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	tests := []struct {
+		name     string
+		jitter   float64
+		min, max time.Duration
+	}{
+		{"fraction", 0.2, 80 * time.Millisecond, 120 * time.Millisecond},
+		{"whole", 1, 0, 200 * time.Millisecond},
+		{"above 1", 5, 0, 200 * time.Millisecond},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy := assistant.RetryPolicy{InitialBackoff: 100 * time.Millisecond, Multiplier: 2, Jitter: test.jitter}
+			seen := make(map[time.Duration]bool)
+			for i := 0; i < 1000; i++ {
+				backoff := policy.Backoff(1)
+				if backoff < test.min || backoff > test.max {
+					t.Fatalf("got %v, want between %v and %v", backoff, test.min, test.max)
+				}
+				seen[backoff] = true
+			}
+			if len(seen) < 2 {
+				t.Errorf("got the same backoff every time, want it randomized")
+			}
+		})
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	policy := assistant.RetryPolicy{RetryableCodes: []codes.Code{codes.Unavailable, codes.DeadlineExceeded}}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"retryable status", status.Error(codes.Unavailable, "unavailable"), true},
+		{"other status", status.Error(codes.NotFound, "not found"), false},
+		{"retryable request error", &assistant.RequestError{Op: "getting response", Code: codes.Unavailable, Err: errors.New("unavailable")}, true},
+		{"other request error", &assistant.RequestError{Op: "getting response", Code: codes.InvalidArgument, Err: errors.New("invalid")}, false},
+		{"wrapped status", &assistant.RequestError{Op: "getting response", Code: codes.Unavailable, Err: status.Error(codes.Unavailable, "unavailable")}, true},
+		{"context deadline", context.DeadlineExceeded, true},
+		{"context canceled", context.Canceled, false},
+		{"first response timeout", &assistant.TimeoutError{Err: assistant.ErrFirstResponseTimeout, Timeout: time.Second}, true},
+		{"turn timeout", &assistant.TimeoutError{Err: assistant.ErrTurnTimeout, Timeout: time.Second}, false},
+		{"wrapped turn timeout", &assistant.RequestError{Op: "getting response", Code: codes.DeadlineExceeded, Err: &assistant.TimeoutError{Err: assistant.ErrTurnTimeout, Timeout: time.Second}}, false},
+	}
+	for _, test := range tests {
+		if got := policy.Retryable(test.err); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package assistant
+
+import (
+	"context"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// SynthesizeChunkLength is the longest piece of text Synthesize asks the Assistant to repeat in a single query
+const SynthesizeChunkLength = 250
+
+// abbreviations end with a period without ending the sentence, along with single letters such as initials
+var abbreviations = map[string]bool{"mr": true, "mrs": true, "ms": true, "dr": true, "prof": true, "st": true, "jr": true, "sr": true, "vs": true, "e.g": true, "i.e": true}
+
+// Synthesize speaks text in the Assistant's voice, writing the audio to w as one continuous stream in the configured AudioOutEncoding
+// Long text is split at sentence boundaries, and every piece is sent as a "repeat after me" query on a throwaway conversation that never touches the dialog state of any other conversation
+// Raw LINEAR16 audio joins seamlessly, while MP3 and OGG_OPUS audio is joined as consecutive frames and chained streams respectively
+func (a *Assistant) Synthesize(ctx context.Context, text string, w io.Writer) error {
+	for _, piece := range splitText(text, SynthesizeChunkLength) {
+		conversation, err := a.NewConversationContext(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = conversation.RequestTransportText().QueryAudioContext(ctx, "repeat after me "+piece, w)
+		conversation.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitText splits text into pieces of at most limit bytes, preferring to split between sentences, then between words
+func splitText(text string, limit int) []string {
+	pieces := make([]string, 0)
+	piece := ""
+	for _, sentence := range splitSentences(text) {
+		if piece != "" && len(piece)+1+len(sentence) <= limit {
+			piece += " " + sentence
+			continue
+		}
+		if piece != "" {
+			pieces = append(pieces, piece)
+		}
+		piece = ""
+
+		for len(sentence) > limit {
+			cut := strings.LastIndexFunc(sentence[:limit+1], unicode.IsSpace)
+			if cut <= 0 {
+				cut = limit //A single word longer than the limit can only be cut mid-word
+				for cut > 0 && !utf8.RuneStart(sentence[cut]) {
+					cut--
+				}
+				if cut == 0 {
+					_, cut = utf8.DecodeRuneInString(sentence) //Never cut a rune in half, even if it doesn't fit
+				}
+			}
+			pieces = append(pieces, strings.TrimSpace(sentence[:cut]))
+			sentence = strings.TrimSpace(sentence[cut:])
+		}
+		piece = sentence
+	}
+	if piece != "" {
+		pieces = append(pieces, piece)
+	}
+	return pieces
+}
+
+// splitSentences splits text after every sentence-ending punctuation mark or line break, trimming the whitespace between sentences
+func splitSentences(text string) []string {
+	sentences := make([]string, 0)
+	start := 0
+	for i, r := range text {
+		end := -1
+		switch r {
+		case '\n':
+			end = i
+		case '.', '!', '?', '。', '！', '？':
+			if r == '.' && abbreviated(text[start:i]) {
+				break
+			}
+			next := i + utf8.RuneLen(r)
+			if next == len(text) || unicode.IsSpace(rune(text[next])) || r >= utf8.RuneSelf {
+				end = next
+			}
+		}
+		if end < 0 {
+			continue
+		}
+		if sentence := strings.TrimSpace(text[start:end]); sentence != "" {
+			sentences = append(sentences, sentence)
+		}
+		start = end
+	}
+	if sentence := strings.TrimSpace(text[start:]); sentence != "" {
+		sentences = append(sentences, sentence)
+	}
+	return sentences
+}
+
+// abbreviated returns true if the last word of text is an abbreviation, so a period after it doesn't end the sentence
+func abbreviated(text string) bool {
+	word := strings.ToLower(text[strings.LastIndexFunc(text, unicode.IsSpace)+1:])
+	if r, size := utf8.DecodeRuneInString(word); size == len(word) && unicode.IsLetter(r) {
+		return true
+	}
+	return abbreviations[word]
+}
@@ -0,0 +1,73 @@
+package assistant
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitSentences(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"sentences", "Hello there. How are you? Great!", []string{"Hello there.", "How are you?", "Great!"}},
+		{"line breaks", "first line\nsecond line\n\nthird", []string{"first line", "second line", "third"}},
+		{"decimals", "It costs 3.5 dollars. Pay at 9.30.", []string{"It costs 3.5 dollars.", "Pay at 9.30."}},
+		{"abbreviations", "Dr. Smith met Mr. J. Jones at St. Mary's. They talked.", []string{"Dr. Smith met Mr. J. Jones at St. Mary's.", "They talked."}},
+		{"abbreviations with dots", "Bring fruit, e.g. apples. Or not.", []string{"Bring fruit, e.g. apples.", "Or not."}},
+		{"CJK punctuation", "你好。今天天气很好！我们去公园吧？好", []string{"你好。", "今天天气很好！", "我们去公园吧？", "好"}},
+		{"surrounding whitespace", "  One.   Two.  ", []string{"One.", "Two."}},
+		{"empty", " \n ", []string{}},
+	}
+	for _, test := range tests {
+		if got := splitSentences(test.text); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSplitText(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+		want  []string
+	}{
+		{"fits", "One. Two. Three.", 50, []string{"One. Two. Three."}},
+		{"packs sentences", "One two. Three four. Five six.", 20, []string{"One two. Three four.", "Five six."}},
+		{"exactly the limit", "abcd. efgh.", 11, []string{"abcd. efgh."}},
+		{"splits between words", "one two three four five six", 10, []string{"one two", "three four", "five six"}},
+		{"long word", "abcdefghijkl mn", 5, []string{"abcde", "fghij", "kl mn"}},
+		{"long word of multibyte runes", "ééééé", 5, []string{"éé", "éé", "é"}},
+		{"CJK", "今天天气很好。我们去公园吧。", 21, []string{"今天天气很好。", "我们去公园吧。"}},
+		{"rune longer than the limit", "日本", 2, []string{"日", "本"}},
+	}
+	for _, test := range tests {
+		if got := splitText(test.text, test.limit); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSplitTextLimit(t *testing.T) {
+	text := strings.Repeat("The quick brown fox jumps over the lazy dog. ", 20) +
+		strings.Repeat("Pneumonoultramicroscopicsilicovolcanoconiosis", 10) + " " +
+		strings.Repeat("素早い茶色の狐がのろまな犬を飛び越えた。", 20)
+	for _, limit := range []int{10, 50, SynthesizeChunkLength} {
+		pieces := splitText(text, limit)
+		for _, piece := range pieces {
+			if len(piece) > limit {
+				t.Errorf("limit %d: got a piece of %d bytes", limit, len(piece))
+			}
+			if !utf8.ValidString(piece) || piece == "" || piece != strings.TrimSpace(piece) {
+				t.Errorf("limit %d: got piece %q", limit, piece)
+			}
+		}
+		if got, want := strings.Join(strings.Fields(strings.Join(pieces, "")), ""), strings.Join(strings.Fields(text), ""); got != want {
+			t.Errorf("limit %d: pieces don't add up to the text", limit)
+		}
+	}
+}
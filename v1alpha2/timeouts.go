@@ -0,0 +1,104 @@
+package assistant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	gassist "google.golang.org/genproto/googleapis/assistant/embedded/v1alpha2"
+)
+
+// Errors for each kind of timeout, held by a TimeoutError
+var (
+	ErrDialTimeout          = errors.New("timed out connecting to the Assistant")
+	ErrFirstResponseTimeout = errors.New("timed out waiting for the Assistant to start responding")
+	ErrIdleTimeout          = errors.New("timed out waiting for the Assistant to continue responding")
+	ErrTurnTimeout          = errors.New("timed out waiting for the Assistant to finish responding")
+)
+
+// Timeouts holds how long each part of talking to the Assistant may take, a timeout of 0 never expires
+type Timeouts struct {
+	Dial          time.Duration //How long to wait for the connection to be ready when starting a conversation
+	FirstResponse time.Duration //How long to wait for the first message of every turn
+	Idle          time.Duration //How long to wait between messages of every turn
+	Turn          time.Duration //How long a whole turn may take, from opening its first stream to the Assistant finishing, retries included
+}
+
+// TimeoutError is returned when one of the Timeouts expires, and matches both its kind of timeout and ErrDeadlineExceeded
+// A dial timeout means the connection is dead, while the others mean Google is slow to answer
+type TimeoutError struct {
+	Err     error         //ErrDialTimeout, ErrFirstResponseTimeout, ErrIdleTimeout or ErrTurnTimeout
+	Timeout time.Duration //The timeout that expired
+}
+
+// Error implements error
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%v after %v", e.Err, e.Timeout)
+}
+
+// Is matches ErrDeadlineExceeded
+func (e *TimeoutError) Is(target error) bool {
+	return target == ErrDeadlineExceeded
+}
+
+// Unwrap returns the kind of timeout
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// timedStream ends a turn whose responses don't arrive in time, and reports why the turn was ended
+type timedStream struct {
+	gassist.EmbeddedAssistant_AssistClient
+
+	ctx           context.Context
+	cancel        context.CancelCauseFunc
+	release       func() //Tells the Assistant the stream has finished
+	firstResponse time.Duration
+	idle          time.Duration
+	received      bool //Only touched by the goroutine receiving from the stream
+}
+
+// Recv implements gassist.EmbeddedAssistant_AssistClient, ending the turn if the next message takes too long
+func (s *timedStream) Recv() (*gassist.AssistResponse, error) {
+	timeout, timeoutErr := s.idle, ErrIdleTimeout
+	if !s.received {
+		timeout, timeoutErr = s.firstResponse, ErrFirstResponseTimeout
+	}
+	if timeout > 0 {
+		timer := time.AfterFunc(timeout, func() {
+			s.cancel(&TimeoutError{Err: timeoutErr, Timeout: timeout})
+		})
+		defer timer.Stop()
+	}
+
+	response, err := s.EmbeddedAssistant_AssistClient.Recv()
+	if err != nil {
+		s.release()
+		return nil, s.err(err)
+	}
+	s.received = true
+	return response, nil
+}
+
+// Send implements gassist.EmbeddedAssistant_AssistClient
+func (s *timedStream) Send(request *gassist.AssistRequest) error {
+	return s.err(s.EmbeddedAssistant_AssistClient.Send(request))
+}
+
+// err replaces the error of a turn that was ended early with why it was ended, such as a timeout or its context being done
+func (s *timedStream) err(err error) error {
+	if err == nil || err == io.EOF || s.ctx.Err() == nil {
+		return err
+	}
+	cause := context.Cause(s.ctx)
+	var timeoutErr *TimeoutError
+	if errors.As(cause, &timeoutErr) {
+		return timeoutErr
+	}
+	if errors.Is(cause, context.Canceled) || errors.Is(cause, context.DeadlineExceeded) {
+		return cause
+	}
+	return fmt.Errorf("%w: %w", s.ctx.Err(), cause) //Matches both the custom cause and the standard context error
+}
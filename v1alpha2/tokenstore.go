@@ -0,0 +1,86 @@
+package assistant
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/oauth2"
+)
+
+// TokenStore persists an OAuth2 token between runs, so the user doesn't have to sign in again
+type TokenStore interface {
+	Load() (*oauth2.Token, error) //Returns a nil token and no error if nothing has been stored yet
+	Save(*oauth2.Token) error
+	Delete() error
+}
+
+// FileTokenStore stores an OAuth2 token as JSON in a file only readable by the current user
+type FileTokenStore struct {
+	Path string
+
+	mutex sync.Mutex
+}
+
+// NewFileTokenStore returns a token store backed by the file at path
+func NewFileTokenStore(path string) *FileTokenStore {
+	return &FileTokenStore{Path: path}
+}
+
+// Load implements TokenStore
+func (s *FileTokenStore) Load() (*oauth2.Token, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	tokenJSON, err := os.ReadFile(s.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	token := &oauth2.Token{}
+	if err := json.Unmarshal(tokenJSON, token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// Save implements TokenStore, replacing the file atomically so a crash can't leave a truncated token behind
+func (s *FileTokenStore) Save(token *oauth2.Token) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	tokenJSON, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.Path), filepath.Base(s.Path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(tokenJSON); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.Path)
+}
+
+// Delete implements TokenStore
+func (s *FileTokenStore) Delete() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := os.Remove(s.Path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
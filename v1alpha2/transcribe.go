@@ -0,0 +1,155 @@
+package assistant
+
+import (
+	"context"
+	"sync"
+
+	gassist "google.golang.org/genproto/googleapis/assistant/embedded/v1alpha2"
+)
+
+// Transcript holds what the user has spoken so far in a transcription
+type Transcript struct {
+	Text          string                             //Every part of the speech results joined with spaces
+	Stability     float32                            //Likelihood that the Assistant will not change its guess, see VoiceSession.Transcript
+	SpeechResults []*gassist.SpeechRecognitionResult //The speech results as sent by the Assistant, for their individual stabilities
+}
+
+// Transcription holds a dictation, streaming audio to Google and returning only what the user said
+// The stream is torn down as soon as the Assistant stops listening, so it never answers, acts on, or remembers the utterance
+type Transcription struct {
+	session     *VoiceSession
+	transcripts chan Transcript
+	updates     chan TranscriptUpdate
+	done        chan struct{}
+
+	mutex      sync.Mutex
+	transcript Transcript
+	assembler  TranscriptAssembler
+}
+
+// RequestTranscription returns a transcription of a single utterance on this conversation, leaving its dialog state untouched
+func (c *Conversation) RequestTranscription() *Transcription {
+	session := c.RequestVoiceSession()
+	session.transcribeOnly = true
+	return &Transcription{
+		session: session,
+		done:    make(chan struct{}),
+	}
+}
+
+// Start opens a new stream and begins streaming audio without blocking
+func (t *Transcription) Start() error {
+	return t.StartContext(t.session.Conversation.context())
+}
+
+// StartContext is like Start, but cancelling ctx tears down the transcription without affecting the rest of the conversation
+func (t *Transcription) StartContext(ctx context.Context) error {
+	events := t.session.Events()
+	if err := t.session.StartContext(ctx); err != nil {
+		return err
+	}
+	go t.recvLoop(events)
+	return nil
+}
+
+// Write implements io.Writer and queues a chunk of audio to be transcribed, returning ErrEndOfUtterance once the Assistant has stopped listening
+func (t *Transcription) Write(p []byte) (n int, err error) {
+	return t.session.Write(p)
+}
+
+// WriteContext is like Write, but gives up waiting for room in the queue once ctx is done
+func (t *Transcription) WriteContext(ctx context.Context, p []byte) (n int, err error) {
+	return t.session.WriteContext(ctx, p)
+}
+
+// CloseWrite signals that no more audio will be written, for when the input ends before the Assistant detects the end of the utterance
+func (t *Transcription) CloseWrite() error {
+	return t.session.CloseWrite()
+}
+
+// Transcripts returns a channel of every interim transcript as it arrives, closed once the transcription has finished
+// It must be called before Start, and the channel must be drained for the transcription to finish
+func (t *Transcription) Transcripts() <-chan Transcript {
+	if t.transcripts == nil {
+		t.transcripts = make(chan Transcript, 16)
+	}
+	return t.transcripts
+}
+
+// Updates returns a channel of changes to the assembled transcript, ending with a final update once the transcription has finished, see TranscriptAssembler
+// It must be called before Start, and the channel must be drained for the transcription to finish
+func (t *Transcription) Updates() <-chan TranscriptUpdate {
+	if t.updates == nil {
+		t.updates = make(chan TranscriptUpdate, 16)
+	}
+	return t.updates
+}
+
+// Transcript returns the latest transcript, which is final once the transcription has finished
+func (t *Transcription) Transcript() Transcript {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.transcript
+}
+
+// Wait blocks until the transcription has finished and returns whatever was recognized up to the end of the utterance
+func (t *Transcription) Wait() (Transcript, error) {
+	return t.WaitContext(context.Background())
+}
+
+// WaitContext is like Wait, but gives up waiting once ctx is done
+func (t *Transcription) WaitContext(ctx context.Context) (Transcript, error) {
+	select {
+	case <-t.done:
+	case <-ctx.Done():
+		return t.Transcript(), ctx.Err()
+	}
+	return t.Transcript(), t.session.Wait()
+}
+
+// EndOfUtterance returns a channel that is closed once the Assistant has stopped listening for audio
+func (t *Transcription) EndOfUtterance() <-chan struct{} {
+	return t.session.EndOfUtterance()
+}
+
+// Done returns a channel that is closed once the transcription has finished
+func (t *Transcription) Done() <-chan struct{} {
+	return t.done
+}
+
+// recvLoop keeps the latest transcript from the events of the voice session
+func (t *Transcription) recvLoop(events <-chan Event) {
+	defer func() {
+		if t.transcripts != nil {
+			close(t.transcripts)
+		}
+		if t.updates != nil {
+			t.mutex.Lock()
+			update := t.assembler.Finish()
+			t.mutex.Unlock()
+			t.updates <- update
+			close(t.updates)
+		}
+		close(t.done)
+	}()
+	for event := range events {
+		if event.Type != EventTranscript {
+			continue
+		}
+		transcript := Transcript{
+			Text:          event.Transcript,
+			Stability:     event.Stability,
+			SpeechResults: event.SpeechResults,
+		}
+		t.mutex.Lock()
+		t.transcript = transcript
+		update, changed := t.assembler.Add(event.SpeechResults)
+		t.mutex.Unlock()
+		if t.transcripts != nil {
+			t.transcripts <- transcript
+		}
+		if t.updates != nil && changed {
+			t.updates <- update
+		}
+	}
+}
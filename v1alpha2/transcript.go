@@ -0,0 +1,115 @@
+package assistant
+
+import (
+	"strings"
+
+	gassist "google.golang.org/genproto/googleapis/assistant/embedded/v1alpha2"
+)
+
+// DefaultStableThreshold is the stability a part of the speech results needs before its words are committed, used by a TranscriptAssembler without a threshold of its own
+const DefaultStableThreshold = 0.9
+
+// TranscriptUpdate holds a change to an assembled transcript, made so live captions can append committed words and replace only the volatile tail
+type TranscriptUpdate struct {
+	Committed string //Words committed by this update, to be appended to the previous stable prefix
+	Stable    string //The whole stable prefix, which never changes once committed
+	Volatile  string //The words the Assistant may still change, replacing the previous volatile tail
+	Final     bool   //Whether this is the last update, with every word committed
+}
+
+// Text returns the stable prefix followed by the volatile tail
+func (u TranscriptUpdate) Text() string {
+	return joinWords(u.Stable, u.Volatile)
+}
+
+// TranscriptAssembler follows the speech results of a turn, keeping the committed stable prefix apart from the volatile tail
+// Every part of the speech results is judged by its own stability, and the leading parts at or above StableThreshold are committed
+// Committed words are never taken back, if the Assistant later changes its guess about them the new words in their place are dropped, so captions don't flicker or repeat words
+type TranscriptAssembler struct {
+	StableThreshold float32 //Stability a part needs to be committed, 0 for DefaultStableThreshold
+
+	stable   []string
+	volatile []string
+	final    bool
+}
+
+// NewTranscriptAssembler returns a new transcript assembler for a single turn
+func NewTranscriptAssembler() *TranscriptAssembler {
+	return &TranscriptAssembler{StableThreshold: DefaultStableThreshold}
+}
+
+// Add merges the latest speech results of the turn, returning the update and whether anything changed
+func (t *TranscriptAssembler) Add(speechResults []*gassist.SpeechRecognitionResult) (update TranscriptUpdate, changed bool) {
+	if t.final || len(speechResults) == 0 {
+		return t.update(nil), false
+	}
+	threshold := t.StableThreshold
+	if threshold == 0 {
+		threshold = DefaultStableThreshold
+	}
+
+	words := make([]string, 0)
+	stableWords := 0
+	stablePrefix := true
+	for _, speechResult := range speechResults {
+		words = append(words, strings.Fields(speechResult.Transcript)...)
+		if stablePrefix && speechResult.Stability >= threshold {
+			stableWords = len(words)
+		} else {
+			stablePrefix = false
+		}
+	}
+
+	//Words are lined up by position, as the Assistant always sends the whole utterance so far
+	committed := make([]string, 0)
+	if stableWords > len(t.stable) {
+		committed = append(committed, words[len(t.stable):stableWords]...)
+	}
+	volatile := make([]string, 0)
+	if len(words) > len(t.stable)+len(committed) {
+		volatile = append(volatile, words[len(t.stable)+len(committed):]...)
+	}
+
+	changed = len(committed) > 0 || strings.Join(volatile, " ") != strings.Join(t.volatile, " ")
+	t.stable = append(t.stable, committed...)
+	t.volatile = volatile
+	return t.update(committed), changed
+}
+
+// Finish commits the volatile tail once the turn has finished, returning the final update
+func (t *TranscriptAssembler) Finish() TranscriptUpdate {
+	if t.final {
+		return t.update(nil)
+	}
+	committed := t.volatile
+	t.stable = append(t.stable, committed...)
+	t.volatile = nil
+	t.final = true
+	return t.update(committed)
+}
+
+// Transcript returns the stable prefix followed by the volatile tail
+func (t *TranscriptAssembler) Transcript() string {
+	return t.update(nil).Text()
+}
+
+// update returns the current state of the transcript along with the words that were just committed
+func (t *TranscriptAssembler) update(committed []string) TranscriptUpdate {
+	return TranscriptUpdate{
+		Committed: strings.Join(committed, " "),
+		Stable:    strings.Join(t.stable, " "),
+		Volatile:  strings.Join(t.volatile, " "),
+		Final:     t.final,
+	}
+}
+
+// joinWords joins the non-empty pieces of text with spaces
+func joinWords(pieces ...string) string {
+	words := make([]string, 0, len(pieces))
+	for _, piece := range pieces {
+		if piece != "" {
+			words = append(words, piece)
+		}
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,189 @@
+package assistant_test
+
+import (
+	"testing"
+
+	assistant "github.com/JoshuaDoes/google-assistant/v1alpha2"
+	gassist "google.golang.org/genproto/googleapis/assistant/embedded/v1alpha2"
+)
+
+// transcriptStep is either a call to Add with speechResults, or a call to Finish
+type transcriptStep struct {
+	speechResults []*gassist.SpeechRecognitionResult
+	finish        bool
+	want          assistant.TranscriptUpdate
+	changed       bool //Ignored for Finish
+}
+
+func TestTranscriptAssembler(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold float32
+		steps     []transcriptStep
+	}{
+		{
+			name: "parts with different stabilities",
+			steps: []transcriptStep{
+				{
+					speechResults: speechResults("hello world", 0.95, "how are", 0.1),
+					want:          assistant.TranscriptUpdate{Committed: "hello world", Stable: "hello world", Volatile: "how are"},
+					changed:       true,
+				},
+				{
+					speechResults: speechResults("hello world", 0.95, "how are you", 0.9, "doing", 0.2),
+					want:          assistant.TranscriptUpdate{Committed: "how are you", Stable: "hello world how are you", Volatile: "doing"},
+					changed:       true,
+				},
+				{
+					finish: true,
+					want:   assistant.TranscriptUpdate{Committed: "doing", Stable: "hello world how are you doing", Final: true},
+				},
+			},
+		},
+		{
+			name: "stable part after a volatile one",
+			steps: []transcriptStep{
+				{
+					speechResults: speechResults("set a", 0.1, "timer", 0.99),
+					want:          assistant.TranscriptUpdate{Volatile: "set a timer"},
+					changed:       true,
+				},
+			},
+		},
+		{
+			name: "updates after stability 1.0",
+			steps: []transcriptStep{
+				{
+					speechResults: speechResults("turn on", 1.0),
+					want:          assistant.TranscriptUpdate{Committed: "turn on", Stable: "turn on"},
+					changed:       true,
+				},
+				{
+					speechResults: speechResults("turn off the", 1.0),
+					want:          assistant.TranscriptUpdate{Committed: "the", Stable: "turn on the"},
+					changed:       true,
+				},
+				{
+					speechResults: speechResults("turn off the lights", 0.5),
+					want:          assistant.TranscriptUpdate{Stable: "turn on the", Volatile: "lights"},
+					changed:       true,
+				},
+				{
+					speechResults: speechResults("turn", 0.5),
+					want:          assistant.TranscriptUpdate{Stable: "turn on the"},
+					changed:       true,
+				},
+			},
+		},
+		{
+			name: "volatile tail revisions",
+			steps: []transcriptStep{
+				{
+					speechResults: speechResults("whats", 0.1),
+					want:          assistant.TranscriptUpdate{Volatile: "whats"},
+					changed:       true,
+				},
+				{
+					speechResults: speechResults("what's the", 0.1),
+					want:          assistant.TranscriptUpdate{Volatile: "what's the"},
+					changed:       true,
+				},
+				{
+					speechResults: speechResults("what's", 0.9, "the weather", 0.3),
+					want:          assistant.TranscriptUpdate{Committed: "what's", Stable: "what's", Volatile: "the weather"},
+					changed:       true,
+				},
+				{
+					speechResults: speechResults("what's", 0.9, "the whether", 0.3),
+					want:          assistant.TranscriptUpdate{Stable: "what's", Volatile: "the whether"},
+					changed:       true,
+				},
+			},
+		},
+		{
+			name: "repeated result",
+			steps: []transcriptStep{
+				{
+					speechResults: speechResults("play", 0.95, "some music", 0.4),
+					want:          assistant.TranscriptUpdate{Committed: "play", Stable: "play", Volatile: "some music"},
+					changed:       true,
+				},
+				{
+					speechResults: speechResults("play", 0.95, "some music", 0.4),
+					want:          assistant.TranscriptUpdate{Stable: "play", Volatile: "some music"},
+				},
+				{
+					want: assistant.TranscriptUpdate{Stable: "play", Volatile: "some music"},
+				},
+			},
+		},
+		{
+			name: "finish commits the tail once",
+			steps: []transcriptStep{
+				{
+					speechResults: speechResults("good", 0.95, "night", 0.5),
+					want:          assistant.TranscriptUpdate{Committed: "good", Stable: "good", Volatile: "night"},
+					changed:       true,
+				},
+				{
+					finish: true,
+					want:   assistant.TranscriptUpdate{Committed: "night", Stable: "good night", Final: true},
+				},
+				{
+					finish: true,
+					want:   assistant.TranscriptUpdate{Stable: "good night", Final: true},
+				},
+				{
+					speechResults: speechResults("good morning", 1.0),
+					want:          assistant.TranscriptUpdate{Stable: "good night", Final: true},
+				},
+			},
+		},
+		{
+			name:      "custom threshold",
+			threshold: 0.5,
+			steps: []transcriptStep{
+				{
+					speechResults: speechResults("call", 0.6, "mom", 0.4),
+					want:          assistant.TranscriptUpdate{Committed: "call", Stable: "call", Volatile: "mom"},
+					changed:       true,
+				},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assembler := &assistant.TranscriptAssembler{StableThreshold: test.threshold}
+			for i, step := range test.steps {
+				var update assistant.TranscriptUpdate
+				changed := step.changed
+				if step.finish {
+					update = assembler.Finish()
+				} else {
+					update, changed = assembler.Add(step.speechResults)
+				}
+				if update != step.want {
+					t.Errorf("step %d got %+v, want %+v", i+1, update, step.want)
+				}
+				if changed != step.changed {
+					t.Errorf("step %d got changed %v, want %v", i+1, changed, step.changed)
+				}
+				if transcript := assembler.Transcript(); transcript != step.want.Text() {
+					t.Errorf("step %d got transcript %q, want %q", i+1, transcript, step.want.Text())
+				}
+			}
+		})
+	}
+}
+
+// speechResults returns speech results from pairs of transcripts and stabilities
+func speechResults(pairs ...interface{}) []*gassist.SpeechRecognitionResult {
+	results := make([]*gassist.SpeechRecognitionResult, 0)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		results = append(results, &gassist.SpeechRecognitionResult{
+			Transcript: pairs[i].(string),
+			Stability:  float32(pairs[i+1].(float64)),
+		})
+	}
+	return results
+}
@@ -0,0 +1,358 @@
+package assistant
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	gassist "google.golang.org/genproto/googleapis/assistant/embedded/v1alpha2"
+)
+
+// VoiceSession holds a full-duplex audio query, streaming audio to Google on one goroutine while receiving responses on another
+type VoiceSession struct {
+	Conversation *Conversation
+
+	stream         gassist.EmbeddedAssistant_AssistClient
+	ctx            context.Context         //Ends with the stream
+	cancel         context.CancelCauseFunc //Ends the stream
+	transcribeOnly bool                    //Whether to end the turn at the end of the utterance, see Transcription
+	recvDone       chan struct{}
+	sendDone       chan struct{}
+	audioIn        chan []byte
+	audioOut       chan []byte
+	pending        []byte
+	endOfUtterance chan struct{}
+	closeWrite     chan struct{}
+	done           chan struct{}
+	events         chan Event
+
+	utteranceOnce  sync.Once
+	closeWriteOnce sync.Once
+
+	mutex                      sync.Mutex
+	speechRecognitionResult    string
+	speechRecognitionStability float32
+	dialogStateOut             *gassist.DialogStateOut
+	err                        error
+}
+
+// RequestVoiceSession returns a voice session for a single spoken turn of this conversation
+func (c *Conversation) RequestVoiceSession() *VoiceSession {
+	return &VoiceSession{
+		Conversation:   c,
+		audioIn:        make(chan []byte, 16),
+		audioOut:       make(chan []byte, 64),
+		endOfUtterance: make(chan struct{}),
+		closeWrite:     make(chan struct{}),
+		done:           make(chan struct{}),
+		recvDone:       make(chan struct{}),
+		sendDone:       make(chan struct{}),
+	}
+}
+
+// Start opens a new stream, sends the audio configuration once and begins streaming in both directions without blocking
+// The returned audio must be drained with Read (or io.Copy to io.Discard) for the session to finish
+func (s *VoiceSession) Start() error {
+	return s.StartContext(s.Conversation.context())
+}
+
+// StartContext is like Start, but cancelling ctx tears down the session without affecting the rest of the conversation
+func (s *VoiceSession) StartContext(ctx context.Context) error {
+	if err := s.Conversation.Assistant.checkAuth(); err != nil {
+		return err
+	}
+	settings := s.Conversation.Assistant.AudioSettings
+	if err := settings.validate(true); err != nil {
+		return err
+	}
+
+	//Only opening the stream is retried, as audio can't be sent again once it's been streamed
+	ctx, endTurn := s.Conversation.startTurn(ctx)
+	err := s.Conversation.Assistant.retry(ctx, func() (bool, error) {
+		if err := s.Conversation.refresh(ctx, 0); err != nil {
+			return true, err
+		}
+		s.stream = s.Conversation.AssistClient
+		s.ctx = s.Conversation.streamContext()
+		return true, s.sendConfig(settings)
+	})
+	if err != nil {
+		endTurn()
+		return err
+	}
+	s.Conversation.keepTurn(endTurn)
+	s.cancel = s.Conversation.cancel
+
+	go s.sendLoop()
+	go s.recvLoop()
+	return nil
+}
+
+// sendConfig sends the configuration of the voice query, which must be the first request on the stream
+func (s *VoiceSession) sendConfig(settings *AudioSettings) error {
+	err := s.stream.Send(&gassist.AssistRequest{
+		Type: &gassist.AssistRequest_Config{
+			Config: &gassist.AssistConfig{
+				Type: &gassist.AssistConfig_AudioInConfig{
+					AudioInConfig: &gassist.AudioInConfig{
+						Encoding:        settings.AudioInEncoding,
+						SampleRateHertz: settings.AudioInSampleRateHertz,
+					},
+				},
+				AudioOutConfig: s.Conversation.audioOutConfig(),
+				DeviceConfig:   s.Conversation.Assistant.Device.DeviceConfig,
+				DialogStateIn:  s.Conversation.dialogStateIn(),
+			},
+		},
+	})
+	return newRequestError("sending request", err)
+}
+
+// Write implements io.Writer and queues a chunk of audio to be streamed to Google, returning ErrEndOfUtterance once the Assistant has stopped listening
+func (s *VoiceSession) Write(p []byte) (n int, err error) {
+	return s.WriteContext(context.Background(), p)
+}
+
+// WriteContext is like Write, but gives up waiting for room in the queue once ctx is done
+func (s *VoiceSession) WriteContext(ctx context.Context, p []byte) (n int, err error) {
+	chunk := make([]byte, len(p))
+	copy(chunk, p)
+
+	if s.utteranceEnded() { //Checked on its own, as the turn may also have finished by now
+		return 0, ErrEndOfUtterance
+	}
+	select {
+	case <-s.closeWrite:
+		return 0, io.ErrClosedPipe
+	case <-s.done:
+		return 0, s.doneErr()
+	default:
+	}
+
+	select {
+	case s.audioIn <- chunk:
+		return len(p), nil
+	case <-s.endOfUtterance:
+		return 0, ErrEndOfUtterance
+	case <-s.closeWrite:
+		return 0, io.ErrClosedPipe
+	case <-s.done:
+		return 0, s.doneErr()
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// CloseWrite signals that no more audio will be written, for when the input ends before the Assistant detects the end of the utterance
+func (s *VoiceSession) CloseWrite() error {
+	s.closeWriteOnce.Do(func() {
+		close(s.closeWrite)
+	})
+	return nil
+}
+
+// Read implements io.Reader and reads audio returned by Google, returning io.EOF once the turn has finished
+func (s *VoiceSession) Read(p []byte) (n int, err error) {
+	return s.ReadContext(context.Background(), p)
+}
+
+// ReadContext is like Read, but gives up waiting for audio once ctx is done
+func (s *VoiceSession) ReadContext(ctx context.Context, p []byte) (n int, err error) {
+	for len(s.pending) == 0 {
+		select {
+		case audioOut, ok := <-s.audioOut:
+			if !ok {
+				if err := s.Wait(); err != nil {
+					return 0, err
+				}
+				return 0, io.EOF
+			}
+			s.pending = audioOut
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+
+	n = copy(p, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+// Wait blocks until the turn has finished and returns the error that ended it, if any
+func (s *VoiceSession) Wait() error {
+	return s.WaitContext(context.Background())
+}
+
+// WaitContext is like Wait, but gives up waiting once ctx is done
+func (s *VoiceSession) WaitContext(ctx context.Context) error {
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.err
+}
+
+// EndOfUtterance returns a channel that is closed once the Assistant has stopped listening for audio
+func (s *VoiceSession) EndOfUtterance() <-chan struct{} {
+	return s.endOfUtterance
+}
+
+// Events returns a channel of every typed event in the turn, closed after EventTurnDone
+// It must be called before Start, and the channel must be drained alongside Read for the session to finish
+func (s *VoiceSession) Events() <-chan Event {
+	if s.events == nil {
+		s.events = make(chan Event, 16)
+	}
+	return s.events
+}
+
+// Done returns a channel that is closed once the turn has finished
+func (s *VoiceSession) Done() <-chan struct{} {
+	return s.done
+}
+
+// Transcript returns a transcript of words that the user has spoken so far, as well as an estimate of the likelihood that the Assistant will not change its guess about this result (0.0 = unset, 0.1 = unstable, 1.0 = stable and final)
+func (s *VoiceSession) Transcript() (transcript string, stability float32) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.speechRecognitionResult, s.speechRecognitionStability
+}
+
+// DialogStateOut returns the final dialog state of the turn, or nil if it hasn't arrived yet
+func (s *VoiceSession) DialogStateOut() *gassist.DialogStateOut {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.dialogStateOut
+}
+
+// utteranceEnded returns true once the Assistant has stopped listening for audio
+func (s *VoiceSession) utteranceEnded() bool {
+	select {
+	case <-s.endOfUtterance:
+		return true
+	default:
+		return false
+	}
+}
+
+// doneErr returns why audio can't be written once the turn has finished
+func (s *VoiceSession) doneErr() error {
+	if s.utteranceEnded() {
+		return ErrEndOfUtterance
+	}
+	if err := s.Wait(); err != nil {
+		return err
+	}
+	return io.ErrClosedPipe
+}
+
+// sendLoop is the only goroutine allowed to send on the stream once it has started
+func (s *VoiceSession) sendLoop() {
+	defer close(s.sendDone)
+	for {
+		select {
+		case chunk := <-s.audioIn:
+			err := s.stream.Send(&gassist.AssistRequest{
+				Type: &gassist.AssistRequest_AudioIn{
+					AudioIn: chunk,
+				},
+			})
+			if err != nil {
+				return //The receiving goroutine will pick up the real error from the stream
+			}
+		case <-s.endOfUtterance:
+			s.stream.CloseSend()
+			return
+		case <-s.closeWrite:
+			s.stream.CloseSend()
+			return
+		case <-s.recvDone:
+			return
+		}
+	}
+}
+
+func (s *VoiceSession) recvLoop() {
+	var err error
+	defer func() {
+		close(s.recvDone)
+		<-s.sendDone //Nothing may touch the stream once the session is done
+		s.mutex.Lock()
+		s.err = err
+		s.mutex.Unlock()
+		close(s.audioOut)
+		if s.events != nil {
+			sendEvent(s.events, Event{Type: EventTurnDone, Err: err}, s.ctx.Done())
+			close(s.events)
+		}
+		close(s.done)
+	}()
+
+	for {
+		var response *gassist.AssistResponse
+		response, err = s.stream.Recv()
+		if err != nil {
+			if err == io.EOF || (s.transcribeOnly && s.utteranceEnded()) {
+				err = nil //A transcription ends by tearing down the stream once the utterance has ended
+			}
+			err = newRequestError("getting response", err)
+			if authErr, ok := s.Conversation.Assistant.reauthenticate(err); ok {
+				if s.events != nil {
+					sendEvent(s.events, Event{Type: EventReauth, AuthURL: authErr.AuthURL}, s.ctx.Done())
+				}
+				err = authErr
+			}
+			return
+		}
+
+		for _, event := range eventsFromResponse(response) {
+			switch event.Type {
+			case EventEndOfUtterance:
+				s.utteranceOnce.Do(func() {
+					close(s.endOfUtterance)
+				})
+				if s.transcribeOnly {
+					s.cancel(ErrEndOfUtterance) //Stop the Assistant from acting on the utterance
+				}
+			case EventTranscript:
+				s.mutex.Lock()
+				s.speechRecognitionResult = event.Transcript
+				s.speechRecognitionStability = event.Stability
+				s.mutex.Unlock()
+			case EventDialogState:
+				if s.transcribeOnly {
+					continue
+				}
+				s.Conversation.updateDialogState(event.DialogStateOut)
+				s.mutex.Lock()
+				s.dialogStateOut = event.DialogStateOut
+				s.mutex.Unlock()
+			case EventAudioOut:
+				if s.transcribeOnly {
+					continue
+				}
+				s.sendAudio(event.AudioOut)
+			}
+			if s.events != nil {
+				sendEvent(s.events, event, s.ctx.Done())
+			}
+		}
+	}
+}
+
+// sendAudio hands a chunk of audio over to Read, dropping it only once the stream has been torn down and there's no room left, as nobody may be reading anymore
+// Receiving from a stream that has been torn down fails straight away, which ends the session
+func (s *VoiceSession) sendAudio(chunk []byte) {
+	select {
+	case s.audioOut <- chunk:
+		return
+	default:
+	}
+	select {
+	case s.audioOut <- chunk:
+	case <-s.ctx.Done():
+	}
+}